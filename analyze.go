@@ -0,0 +1,609 @@
+package matcher
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// maxTerms is the maximum number of DNF terms examined by the analysis before
+// it gives up.
+const maxTerms = 4096
+
+// Analysis is the result of analyzing a rule for satisfiability.
+type Analysis struct {
+	// Unsatisfiable is true if the rule can never match.
+	Unsatisfiable bool
+	// Tautology is true if the rule always matches.
+	Tautology bool
+	// Incomplete is true if the rule was too large to analyze, in which case
+	// neither of the above is reported.
+	Incomplete bool
+	// Conflicts explains the result. For an unsatisfiable rule, each conflict
+	// lists clauses which cannot match together. For a tautology, each
+	// conflict lists clauses of which at least one always matches.
+	Conflicts []Conflict
+}
+
+// Conflict is a set of clauses of a rule and the reason they conflict.
+type Conflict struct {
+	Clauses Matchers
+	Reason  string
+}
+
+// String converts a Conflict to its corresponding string representation.
+func (c Conflict) String() string {
+	var b bytes.Buffer
+	for i, m := range c.Clauses {
+		if i != 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(m.String())
+	}
+	b.WriteString(": ")
+	b.WriteString(c.Reason)
+	return b.String()
+}
+
+// String converts an Analysis to its corresponding string representation.
+func (a Analysis) String() string {
+	var b bytes.Buffer
+	switch {
+	case a.Incomplete:
+		b.WriteString("rule is too large to analyze")
+	case a.Unsatisfiable:
+		b.WriteString("rule can never match")
+	case a.Tautology:
+		b.WriteString("rule always matches")
+	default:
+		b.WriteString("rule is satisfiable")
+	}
+	for _, c := range a.Conflicts {
+		b.WriteString("\n  ")
+		b.WriteString(c.String())
+	}
+	return b.String()
+}
+
+// Analyze reports whether the rule can never match, or always matches.
+//
+// The analysis reasons about the constraints each clause places on a single
+// field: severity and facility values, exact, prefix and contains matches on
+// hostnames, programs, contents and string KV values, numeric KV intervals and
+// bool KV values. Other matchers are assumed to be satisfiable, so a rule
+// reported as unsatisfiable can never match, but not every rule which can
+// never match is reported.
+func Analyze(m Matcher) Analysis {
+	conflicts, unsat, ok := unsatisfiable(m)
+	if !ok {
+		return Analysis{Incomplete: true}
+	}
+	if unsat {
+		return Analysis{Unsatisfiable: true, Conflicts: conflicts}
+	}
+
+	conflicts, unsat, ok = unsatisfiable(NewUnaryOp(Not, m))
+	if !ok {
+		return Analysis{Incomplete: true}
+	}
+	if unsat {
+		// The negation never matches, so at least one of the negated
+		// clauses of each conflict always matches.
+		for i, c := range conflicts {
+			for j, clause := range c.Clauses {
+				conflicts[i].Clauses[j] = negate(clause)
+			}
+		}
+		return Analysis{Tautology: true, Conflicts: conflicts}
+	}
+
+	return Analysis{}
+}
+
+// unsatisfiable returns true if the matcher can never match, along with the
+// conflicts found in each of its DNF terms. It returns false for ok if the
+// matcher has too many terms to analyze.
+func unsatisfiable(m Matcher) (conflicts []Conflict, unsat bool, ok bool) {
+	terms, ok := dnfTerms(m, false)
+	if !ok {
+		return nil, false, false
+	}
+
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		c, found := termConflict(term)
+		if !found {
+			return nil, false, true
+		}
+		if s := c.String(); !seen[s] {
+			seen[s] = true
+			conflicts = append(conflicts, c)
+		}
+	}
+
+	return conflicts, true, true
+}
+
+// dnfTerms returns the DNF terms of the matcher, or of its negation, without
+// folding any clauses, so that conflicting clauses can be reported. It
+// returns false if the matcher has more than maxTerms terms.
+func dnfTerms(m Matcher, negated bool) ([]Matchers, bool) {
+	switch o := m.(type) {
+	case *UnaryOp:
+		if o.Type == Not {
+			return dnfTerms(o.Matcher, !negated)
+		}
+	case *AdaptiveOp:
+		return dnfTerms(o.frozen(), negated)
+	case *NAryOp:
+		switch {
+		case (o.Type == And) != negated && (o.Type == And || o.Type == Or):
+			out := []Matchers{{}}
+			for _, c := range o.Matchers {
+				cs, ok := dnfTerms(c, negated)
+				if !ok || len(out)*len(cs) > maxTerms {
+					return nil, false
+				}
+				var next []Matchers
+				for _, a := range out {
+					for _, b := range cs {
+						next = append(next, append(append(Matchers{}, a...), b...))
+					}
+				}
+				out = next
+			}
+			return out, true
+		case o.Type == And || o.Type == Or:
+			var out []Matchers
+			for _, c := range o.Matchers {
+				cs, ok := dnfTerms(c, negated)
+				if !ok || len(out)+len(cs) > maxTerms {
+					return nil, false
+				}
+				out = append(out, cs...)
+			}
+			return out, true
+		case o.Type == Implies && len(o.Matchers) > 0:
+			cs := make(Matchers, len(o.Matchers))
+			for i, c := range o.Matchers[:len(o.Matchers)-1] {
+				cs[i] = NewUnaryOp(Not, c)
+			}
+			cs[len(cs)-1] = o.Matchers[len(o.Matchers)-1]
+			return dnfTerms(NewNAryOp(Or, cs...), negated)
+		}
+
+		// Other operations are expanded into and, or and not. Clauses may
+		// be folded, so a folded operation is kept as a single clause.
+		e := expand(Simplify(m))
+		if negated {
+			e = negate(e)
+		}
+		if _, ok := e.(*Constant); ok {
+			if negated {
+				return []Matchers{{NewUnaryOp(Not, m)}}, true
+			}
+			return []Matchers{{m}}, true
+		}
+		return dnfTerms(e, false)
+	}
+
+	if negated {
+		return []Matchers{{NewUnaryOp(Not, m)}}, true
+	}
+	return []Matchers{{m}}, true
+}
+
+// literal is a leaf clause of a DNF term.
+type literal struct {
+	clause  Matcher
+	leaf    Matcher
+	negated bool
+}
+
+// termConflict returns a conflict between the clauses of the DNF term, if
+// one is found.
+func termConflict(term Matchers) (Conflict, bool) {
+	groups := make(map[string][]literal)
+	var order []string
+
+	for _, clause := range term {
+		l := literal{clause: clause, leaf: clause}
+		if o, ok := clause.(*UnaryOp); ok && o.Type == Not {
+			l.leaf = o.Matcher
+			l.negated = true
+		}
+
+		if k, ok := l.leaf.(*Constant); ok {
+			if k.Value == l.negated {
+				return Conflict{Clauses: Matchers{clause}, Reason: "clause never matches"}, true
+			}
+			continue
+		}
+		if s, ok := Simplify(clause).(*Constant); ok && !s.Value {
+			return Conflict{Clauses: Matchers{clause}, Reason: "clause never matches"}, true
+		}
+
+		key := fieldKey(l.leaf)
+		if key == "" {
+			continue
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], l)
+	}
+
+	for _, key := range order {
+		ls := groups[key]
+		var (
+			c     Conflict
+			found bool
+		)
+		switch key {
+		case "severity":
+			c, found = finiteConflict(ls, "severity", severityDomain())
+		case "facility":
+			c, found = finiteConflict(ls, "facility", facilityDomain())
+		default:
+			c, found = valueConflict(ls, !strings.HasPrefix(key, "kv:"))
+		}
+		if found {
+			return c, true
+		}
+	}
+
+	return Conflict{}, false
+}
+
+// fieldKey returns a key identifying the field constrained by a leaf matcher,
+// or an empty string if the leaf is not understood by the analysis or is a
+// template.
+func fieldKey(m Matcher) string {
+	if templated(m) {
+		// The constraint of a template is unknown until it is bound.
+		return ""
+	}
+	switch o := m.(type) {
+	case *Severity:
+		return "severity"
+	case *Facility:
+		return "facility"
+	case *Hostname:
+		return "host"
+	case *Value:
+		return o.Type.String()
+	case *KV:
+		kind := reflect.ValueOf(o.Value).Kind()
+		switch kind {
+		case reflect.String, reflect.Float64, reflect.Bool:
+			return fmt.Sprintf("kv:%s:%s:%d:%s", o.Format, o.keyString(), o.Coercion, kind)
+		}
+	}
+	return ""
+}
+
+// severityDomain returns a message for every possible severity.
+func severityDomain() []captainslog.SyslogMsg {
+	var out []captainslog.SyslogMsg
+	for s := captainslog.Emerg; s <= captainslog.Debug; s++ {
+		m := captainslog.NewSyslogMsg()
+		m.Pri.Severity = s
+		out = append(out, m)
+	}
+	return out
+}
+
+// facilityDomain returns a message for every possible facility.
+func facilityDomain() []captainslog.SyslogMsg {
+	var out []captainslog.SyslogMsg
+	for f := captainslog.Kern; f <= captainslog.Local7; f++ {
+		m := captainslog.NewSyslogMsg()
+		m.Pri.Facility = f
+		out = append(out, m)
+	}
+	return out
+}
+
+// finiteConflict finds a conflict between clauses on a field with a small
+// domain by evaluating every clause on every value of the domain.
+func finiteConflict(ls []literal, field string, domain []captainslog.SyslogMsg) (Conflict, bool) {
+	allowed := make([]bool, len(domain))
+	for i := range allowed {
+		allowed[i] = true
+	}
+
+	var clauses Matchers
+	for _, l := range ls {
+		narrowed := false
+		any := false
+		for i, m := range domain {
+			if !allowed[i] {
+				continue
+			}
+			if l.clause.Matches(m) {
+				any = true
+			} else {
+				allowed[i] = false
+				narrowed = true
+			}
+		}
+		if narrowed {
+			clauses = append(clauses, l.clause)
+		}
+		if !any {
+			return Conflict{Clauses: clauses, Reason: fmt.Sprintf("no %s matches every clause", field)}, true
+		}
+	}
+
+	return Conflict{}, false
+}
+
+// valueConflict finds a conflict between clauses on a string, numeric or bool
+// field. If the field is not always present, negated clauses only constrain
+// the field when a clause which isn't negated ensures it is present.
+func valueConflict(ls []literal, present bool) (Conflict, bool) {
+	hasPositive := false
+	for _, l := range ls {
+		if !l.negated {
+			hasPositive = true
+		}
+	}
+	if !present && !hasPositive {
+		return Conflict{}, false
+	}
+
+	// A clause and its negation conflict.
+	for i, a := range ls {
+		for _, b := range ls[i+1:] {
+			if a.negated != b.negated && equal(a.leaf, b.leaf) {
+				return Conflict{Clauses: Matchers{a.clause, b.clause}, Reason: "clause conflicts with its negation"}, true
+			}
+		}
+	}
+
+	switch kvKind(ls[0].leaf) {
+	case reflect.Float64:
+		return numericConflict(ls)
+	case reflect.Bool:
+		return boolConflict(ls)
+	}
+	return stringConflict(ls)
+}
+
+// kvKind returns the kind of the value of a KV leaf, or reflect.String for
+// any other leaf.
+func kvKind(m Matcher) reflect.Kind {
+	if kv, ok := m.(*KV); ok {
+		return reflect.ValueOf(kv.Value).Kind()
+	}
+	return reflect.String
+}
+
+// stringConstraint returns the match type and string of a string leaf. An
+// equals match is returned as an exact match where the leaf treats it as such,
+// and a match type the leaf never matches is returned as -1.
+func stringConstraint(m Matcher) (MatchType, string) {
+	switch o := m.(type) {
+	case *Hostname:
+		switch o.MatchType {
+		case ExactMatch, PrefixMatch, Contains, Regex:
+			return o.MatchType, o.NameMatcher
+		}
+		return -1, o.NameMatcher
+	case *Value:
+		if o.MatchType == Equals {
+			return ExactMatch, o.Value
+		}
+		return o.MatchType, o.Value
+	case *KV:
+		if o.MatchType == Equals {
+			return ExactMatch, reflect.ValueOf(o.Value).String()
+		}
+		return o.MatchType, reflect.ValueOf(o.Value).String()
+	}
+	return -1, ""
+}
+
+// stringConflict finds a conflict between exact, prefix and contains clauses
+// on a string field.
+func stringConflict(ls []literal) (Conflict, bool) {
+	var exact, prefix *literal
+	var exactVal, prefixVal string
+
+	for i := range ls {
+		l := &ls[i]
+		if l.negated {
+			continue
+		}
+		t, v := stringConstraint(l.leaf)
+		switch t {
+		case ExactMatch, PrefixMatch, Contains, Regex:
+		default:
+			return Conflict{Clauses: Matchers{l.clause}, Reason: "clause never matches"}, true
+		}
+		switch t {
+		case ExactMatch:
+			if exact != nil && exactVal != v {
+				return Conflict{Clauses: Matchers{exact.clause, l.clause}, Reason: "field cannot equal both values"}, true
+			}
+			exact, exactVal = l, v
+		case PrefixMatch:
+			if prefix != nil && !strings.HasPrefix(v, prefixVal) && !strings.HasPrefix(prefixVal, v) {
+				return Conflict{Clauses: Matchers{prefix.clause, l.clause}, Reason: "field cannot have both prefixes"}, true
+			}
+			if prefix == nil || len(v) > len(prefixVal) {
+				prefix, prefixVal = l, v
+			}
+		}
+	}
+
+	if exact != nil {
+		// Every other clause must match the exact value.
+		for _, l := range ls {
+			if l.clause == exact.clause {
+				continue
+			}
+			t, v := stringConstraint(l.leaf)
+			if compareString(t, exactVal, v) == l.negated {
+				return Conflict{Clauses: Matchers{exact.clause, l.clause}, Reason: fmt.Sprintf("%q does not match", exactVal)}, true
+			}
+		}
+		return Conflict{}, false
+	}
+
+	for _, l := range ls {
+		if !l.negated {
+			continue
+		}
+		t, v := stringConstraint(l.leaf)
+		switch t {
+		case PrefixMatch:
+			if strings.HasPrefix(prefixVal, v) {
+				return prefixConflict(prefix, l, "field must have the prefix")
+			}
+		case Contains:
+			if strings.Contains(prefixVal, v) {
+				return prefixConflict(prefix, l, "field must contain the value")
+			}
+			for _, p := range ls {
+				if pt, pv := stringConstraint(p.leaf); !p.negated && pt == Contains && strings.Contains(pv, v) {
+					return Conflict{Clauses: Matchers{p.clause, l.clause}, Reason: "field must contain the value"}, true
+				}
+			}
+		}
+	}
+
+	return Conflict{}, false
+}
+
+// prefixConflict returns a conflict between a negated clause and the longest
+// prefix clause, if any.
+func prefixConflict(prefix *literal, l literal, reason string) (Conflict, bool) {
+	if prefix == nil {
+		return Conflict{Clauses: Matchers{l.clause}, Reason: reason}, true
+	}
+	return Conflict{Clauses: Matchers{prefix.clause, l.clause}, Reason: reason}, true
+}
+
+// bound is one end of a numeric interval.
+type bound struct {
+	value     float64
+	inclusive bool
+	clause    Matcher
+}
+
+// numericConflict finds a conflict between numeric clauses by intersecting
+// the intervals they allow.
+func numericConflict(ls []literal) (Conflict, bool) {
+	lo := bound{value: math.Inf(-1)}
+	hi := bound{value: math.Inf(1)}
+	var excluded []literal
+
+	raise := func(b bound) {
+		if b.value > lo.value || (b.value == lo.value && !b.inclusive) {
+			lo = b
+		}
+	}
+	lower := func(b bound) {
+		if b.value < hi.value || (b.value == hi.value && !b.inclusive) {
+			hi = b
+		}
+	}
+
+	for _, l := range ls {
+		kv := l.leaf.(*KV)
+		v := kv.Value.(float64)
+		t := kv.MatchType
+		if l.negated {
+			// The field is present and numeric, so a negated comparison is
+			// the opposite comparison.
+			switch t {
+			case LessThan:
+				t = GreaterThanEqual
+			case LessThanEqual:
+				t = GreaterThan
+			case GreaterThan:
+				t = LessThanEqual
+			case GreaterThanEqual:
+				t = LessThan
+			case Equals:
+				excluded = append(excluded, l)
+				continue
+			default:
+				// The comparison never matches, so its negation always does.
+				continue
+			}
+		}
+
+		b := bound{value: v, clause: l.clause}
+		switch t {
+		case LessThan:
+			lower(b)
+		case LessThanEqual:
+			b.inclusive = true
+			lower(b)
+		case GreaterThan:
+			raise(b)
+		case GreaterThanEqual:
+			b.inclusive = true
+			raise(b)
+		case Equals:
+			b.inclusive = true
+			raise(b)
+			lower(b)
+		default:
+			return Conflict{Clauses: Matchers{l.clause}, Reason: "clause never matches"}, true
+		}
+	}
+
+	if lo.value > hi.value || (lo.value == hi.value && !(lo.inclusive && hi.inclusive)) {
+		return Conflict{Clauses: boundClauses(lo, hi), Reason: "no value is within every bound"}, true
+	}
+	if lo.value == hi.value {
+		for _, l := range excluded {
+			if l.leaf.(*KV).Value.(float64) == lo.value {
+				return Conflict{Clauses: append(boundClauses(lo, hi), l.clause), Reason: "the only value within every bound is excluded"}, true
+			}
+		}
+	}
+
+	return Conflict{}, false
+}
+
+// boundClauses returns the distinct clauses of two bounds.
+func boundClauses(lo, hi bound) Matchers {
+	if lo.clause == hi.clause {
+		return Matchers{lo.clause}
+	}
+	return Matchers{lo.clause, hi.clause}
+}
+
+// boolConflict finds a conflict between bool clauses.
+func boolConflict(ls []literal) (Conflict, bool) {
+	var want *literal
+	for i := range ls {
+		l := &ls[i]
+		kv := l.leaf.(*KV)
+		if kv.MatchType != Equals {
+			if !l.negated {
+				return Conflict{Clauses: Matchers{l.clause}, Reason: "clause never matches"}, true
+			}
+			continue
+		}
+		if want == nil {
+			want = l
+			continue
+		}
+		a := want.leaf.(*KV).Value.(bool) != want.negated
+		b := kv.Value.(bool) != l.negated
+		if a != b {
+			return Conflict{Clauses: Matchers{want.clause, l.clause}, Reason: "field cannot be both true and false"}, true
+		}
+	}
+
+	return Conflict{}, false
+}
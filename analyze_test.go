@@ -0,0 +1,110 @@
+package matcher
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestAnalyzeUnsatisfiable(t *testing.T) {
+	for _, tc := range []struct {
+		rule    Matcher
+		clauses int
+	}{
+		{NewNAryOp(And, NewSeverity(LessThan, captainslog.Warning), NewSeverity(GreaterThan, captainslog.Err)), 2},
+		{NewNAryOp(And, NewValue(Program, ExactMatch, "a"), NewValue(Program, ExactMatch, "b")), 2},
+		{NewNAryOp(And, NewHostname(PrefixMatch, "web-"), NewHostname(PrefixMatch, "db-")), 2},
+		{NewNAryOp(And, NewHostname(ExactMatch, "db-1"), NewHostname(PrefixMatch, "web-")), 2},
+		{NewNAryOp(And, NewHostname(PrefixMatch, "web-1"), NewUnaryOp(Not, NewHostname(PrefixMatch, "web-"))), 2},
+		{NewNAryOp(And, NewValue(Content, Contains, "timeout"), NewUnaryOp(Not, NewValue(Content, Contains, "time"))), 2},
+		{NewNAryOp(And, NewKV("status", GreaterThanEqual, 500), NewKV("status", LessThan, 400)), 2},
+		{NewNAryOp(And, NewKV("status", GreaterThanEqual, 500), NewKV("status", LessThanEqual, 500), NewUnaryOp(Not, NewKV("status", Equals, 500))), 3},
+		{NewNAryOp(And, NewKV("cached", Equals, true), NewKV("cached", Equals, false)), 2},
+		{NewNAryOp(And, NewFacility(captainslog.Kern), NewFacility(captainslog.Mail)), 2},
+		{NewNAryOp(Or,
+			NewNAryOp(And, NewValue(Program, ExactMatch, "a"), NewValue(Program, ExactMatch, "b")),
+			NewNAryOp(And, NewSeverity(Equals, captainslog.Err), NewSeverity(Equals, captainslog.Info))), 2},
+	} {
+		a := Analyze(tc.rule)
+		if !a.Unsatisfiable {
+			t.Errorf("%s was not reported as unsatisfiable: %s", tc.rule, a)
+			continue
+		}
+		if len(a.Conflicts) == 0 || len(a.Conflicts[0].Clauses) != tc.clauses {
+			t.Errorf("%s: want %d conflicting clauses, got %s", tc.rule, tc.clauses, a)
+		}
+	}
+}
+
+func TestAnalyzeSatisfiable(t *testing.T) {
+	for _, rule := range []Matcher{
+		NewNAryOp(And, NewSeverity(LessThan, captainslog.Err), NewSeverity(GreaterThan, captainslog.Notice)),
+		NewNAryOp(And, NewHostname(PrefixMatch, "web-"), NewHostname(PrefixMatch, "web-1")),
+		NewNAryOp(And, NewHostname(PrefixMatch, "web-"), NewUnaryOp(Not, NewHostname(ExactMatch, "web-"))),
+		NewNAryOp(And, NewKV("status", GreaterThanEqual, 500), NewKV("status", LessThanEqual, 500)),
+		// A missing key satisfies every negated clause.
+		NewNAryOp(And, NewUnaryOp(Not, NewKV("status", LessThan, 400)), NewUnaryOp(Not, NewKV("status", GreaterThanEqual, 400))),
+		NewNAryOp(And, NewKV("status", GreaterThanEqual, 500), NewKV("other", LessThan, 400)),
+		// A hostname equals match never matches, so its negation always does.
+		NewNAryOp(And, NewHostname(ExactMatch, "x"), NewUnaryOp(Not, NewHostname(Equals, "x"))),
+	} {
+		if a := Analyze(rule); a.Unsatisfiable || a.Tautology || a.Incomplete {
+			t.Errorf("%s: want satisfiable, got %s", rule, a)
+		}
+	}
+}
+
+func TestAnalyzeTautology(t *testing.T) {
+	rule := NewNAryOp(Or, NewSeverity(LessThan, captainslog.Warning), NewSeverity(GreaterThanEqual, captainslog.Warning))
+	a := Analyze(rule)
+	if !a.Tautology {
+		t.Fatalf("%s was not reported as a tautology: %s", rule, a)
+	}
+	if want, got := "severity(lt, warning), severity(gte, warning): no severity matches every clause", a.Conflicts[0].String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	rule = NewNAryOp(Or, NewHostname(PrefixMatch, "a"), NewUnaryOp(Not, NewHostname(PrefixMatch, "ab")))
+	if a := Analyze(rule); !a.Tautology {
+		t.Errorf("%s was not reported as a tautology: %s", rule, a)
+	}
+}
+
+func TestAnalyzeRandomized(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	msgs := allMessages()
+
+	unsat, taut := 0, 0
+	for i := 0; i < 2000; i++ {
+		tree := randomTree(r, 3)
+		a := Analyze(tree)
+		if a.Incomplete {
+			continue
+		}
+
+		matched := 0
+		for _, m := range msgs {
+			if tree.Matches(m) {
+				matched++
+			}
+		}
+
+		if a.Unsatisfiable {
+			unsat++
+			if matched != 0 {
+				t.Fatalf("%s was reported as unsatisfiable but matched %d messages: %s", tree, matched, a)
+			}
+		}
+		if a.Tautology {
+			taut++
+			if matched != len(msgs) {
+				t.Fatalf("%s was reported as a tautology but did not match %d messages: %s", tree, len(msgs)-matched, a)
+			}
+		}
+	}
+
+	if unsat == 0 || taut == 0 {
+		t.Errorf("randomized trees did not exercise the analysis, unsatisfiable = %d, tautologies = %d", unsat, taut)
+	}
+}
@@ -0,0 +1,295 @@
+package matcher
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// MatchBatch returns the set of the indexes of the messages matched by the
+// supplied matcher. The tree is evaluated column-wise, each leaf matcher
+// evaluating every message still undecided before the next leaf runs, and
+// the results of the children of operations are combined as bitsets. The
+// Severity, Facility, Hostname, Value and KV leaves are compiled once per
+// batch, e.g. their regexes, and compare the messages in a loop of their own. An And
+// only evaluates its next matcher on the messages matched so far, and an Or
+// on those not matched yet, so the short-circuiting of Matches is preserved.
+//
+// The result is the same as calling Matches for every message, with the
+// expiry of Rules checked once per batch.
+func MatchBatch(m Matcher, msgs []captainslog.SyslogMsg) Bitset {
+	mask := NewBitset(len(msgs))
+	mask.Fill()
+	return matchBatch(m, newBatch(msgs), mask)
+}
+
+// MatchBatch returns the set of the indexes of the messages matched by the
+// NAryOp. See the package function MatchBatch.
+func (o *NAryOp) MatchBatch(msgs []captainslog.SyslogMsg) Bitset {
+	return MatchBatch(o, msgs)
+}
+
+// MatchBatch returns the set of the indexes of the messages matched by the
+// UnaryOp. See the package function MatchBatch.
+func (o *UnaryOp) MatchBatch(msgs []captainslog.SyslogMsg) Bitset {
+	return MatchBatch(o, msgs)
+}
+
+// MatchBatch returns the set of the indexes of the messages matched by any of
+// the matchers. A message matched by a matcher is not evaluated by the
+// matchers which follow it.
+func (ms Matchers) MatchBatch(msgs []captainslog.SyslogMsg) Bitset {
+	rest := NewBitset(len(msgs))
+	rest.Fill()
+	return matchAny(ms, newBatch(msgs), rest)
+}
+
+// batch is a batch of messages, with the logfmt content of each message
+// parsed at most once across the matchers evaluating it.
+type batch struct {
+	msgs     []captainslog.SyslogMsg
+	contents []logfmtContent
+}
+
+// newBatch returns a new batch of the supplied messages.
+func newBatch(msgs []captainslog.SyslogMsg) *batch {
+	return &batch{
+		msgs:     msgs,
+		contents: make([]logfmtContent, len(msgs)),
+	}
+}
+
+// matchBatch returns the subset of the messages of the mask matched by the
+// matcher. The mask is not modified.
+func matchBatch(m Matcher, b *batch, mask Bitset) Bitset {
+	switch o := m.(type) {
+	case *Constant:
+		if o.Value {
+			return mask.Clone()
+		}
+		return NewBitset(mask.Len())
+	case *NAryOp:
+		return o.matchBatch(b, mask)
+	case *AdaptiveOp:
+		// The matchers are evaluated in their current order, without
+		// updating the statistics of the operation.
+		return o.frozen().matchBatch(b, mask)
+	case *UnaryOp:
+		out := NewBitset(mask.Len())
+		if o.Type == Not {
+			out.Or(mask)
+			out.AndNot(matchBatch(o.Matcher, b, mask))
+		}
+		return out
+	case *Rule:
+		if !o.Active() {
+			return NewBitset(mask.Len())
+		}
+		return matchBatch(o.Matcher, b, mask)
+	case *Ref:
+		if o.Matcher == nil {
+			return NewBitset(mask.Len())
+		}
+		return matchBatch(o.Matcher, b, mask)
+	case *Severity:
+		return o.matchBatch(b, mask)
+	case *Facility:
+		out := NewBitset(mask.Len())
+		for i := mask.Next(0); i >= 0; i = mask.Next(i + 1) {
+			if b.msgs[i].Pri.Facility == o.Facility {
+				out.Set(i)
+			}
+		}
+		return out
+	case *Hostname:
+		if o.MatchType == Equals {
+			// Unlike the other string matchers, a Hostname never matches
+			// equals.
+			return NewBitset(mask.Len())
+		}
+		return matchStrings(b, mask, HostField, o.MatchType, o.NameMatcher)
+	case *Value:
+		t := o.MatchType
+		if t == Equals {
+			t = ExactMatch
+		}
+		switch o.Type {
+		case Program:
+			return matchStrings(b, mask, ProgramField, t, o.Value)
+		case Content:
+			return matchStrings(b, mask, ContentField, t, o.Value)
+		}
+	case *KV:
+		t := o.compile(true)
+		out := NewBitset(mask.Len())
+		if t.kind == reflect.String && o.MatchType == Regex && t.re == nil {
+			// The pattern is invalid, so the KV never matches.
+			return out
+		}
+		for i := mask.Next(0); i >= 0; i = mask.Next(i + 1) {
+			if t.match(b.msgs[i], &b.contents[i]) {
+				out.Set(i)
+			}
+		}
+		return out
+	}
+
+	out := NewBitset(mask.Len())
+	for i := mask.Next(0); i >= 0; i = mask.Next(i + 1) {
+		if matched, _ := matchContent(m, b.msgs[i], &b.contents[i]); matched {
+			out.Set(i)
+		}
+	}
+	return out
+}
+
+// matchBatch returns the subset of the messages of the mask matched by the
+// Severity.
+func (s *Severity) matchBatch(b *batch, mask Bitset) Bitset {
+	out := NewBitset(mask.Len())
+	for i := mask.Next(0); i >= 0; i = mask.Next(i + 1) {
+		// Syslog severity values are lower for higher severities.
+		v := b.msgs[i].Pri.Severity
+		var matched bool
+		switch s.MatchType {
+		case Equals:
+			matched = v == s.Severity
+		case LessThan:
+			matched = v > s.Severity
+		case LessThanEqual:
+			matched = v >= s.Severity
+		case GreaterThan:
+			matched = v < s.Severity
+		case GreaterThanEqual:
+			matched = v <= s.Severity
+		default:
+			return out
+		}
+		if matched {
+			out.Set(i)
+		}
+	}
+	return out
+}
+
+// fieldString returns the host, program or content of the message.
+func fieldString(m *captainslog.SyslogMsg, f FieldType) string {
+	switch f {
+	case HostField:
+		return m.Host
+	case ProgramField:
+		return m.Tag.Program
+	}
+	return m.Content
+}
+
+// matchStrings returns the subset of the messages of the mask whose host,
+// program or content matches comp under the string match type. A regex is
+// compiled once for the batch.
+func matchStrings(b *batch, mask Bitset, f FieldType, t MatchType, comp string) Bitset {
+	out := NewBitset(mask.Len())
+	var re *regexp.Regexp
+	switch t {
+	case ExactMatch, PrefixMatch, Contains:
+	case Regex:
+		var err error
+		if re, err = regexp.Compile(comp); err != nil {
+			return out
+		}
+	default:
+		return out
+	}
+
+	for i := mask.Next(0); i >= 0; i = mask.Next(i + 1) {
+		v := fieldString(&b.msgs[i], f)
+		var matched bool
+		switch t {
+		case ExactMatch:
+			matched = v == comp
+		case PrefixMatch:
+			matched = strings.HasPrefix(v, comp)
+		case Contains:
+			matched = strings.Contains(v, comp)
+		case Regex:
+			matched = re.MatchString(v)
+		}
+		if matched {
+			out.Set(i)
+		}
+	}
+	return out
+}
+
+// matchAll returns the subset of the messages of the mask matched by every
+// matcher.
+func matchAll(ms Matchers, b *batch, mask Bitset) Bitset {
+	live := mask.Clone()
+	for _, m := range ms {
+		if live.Empty() {
+			break
+		}
+		live = matchBatch(m, b, live)
+	}
+	return live
+}
+
+// matchAny returns the subset of the messages of the mask matched by any
+// matcher.
+func matchAny(ms Matchers, b *batch, mask Bitset) Bitset {
+	out := NewBitset(mask.Len())
+	rest := mask.Clone()
+	for _, m := range ms {
+		if rest.Empty() {
+			break
+		}
+		matched := matchBatch(m, b, rest)
+		out.Or(matched)
+		rest.AndNot(matched)
+	}
+	return out
+}
+
+// matchBatch returns the subset of the messages of the mask matched by the
+// NAryOp.
+func (o *NAryOp) matchBatch(b *batch, mask Bitset) Bitset {
+	switch o.Type {
+	case And:
+		return matchAll(o.Matchers, b, mask)
+	case Or:
+		return matchAny(o.Matchers, b, mask)
+	case Xor:
+		out := NewBitset(mask.Len())
+		for _, m := range o.Matchers {
+			out.Xor(matchBatch(m, b, mask))
+		}
+		return out
+	case Implies:
+		if len(o.Matchers) == 0 {
+			return mask.Clone()
+		}
+		last := len(o.Matchers) - 1
+		premises := matchAll(o.Matchers[:last], b, mask)
+		out := mask.Clone()
+		out.AndNot(premises)
+		out.Or(matchBatch(o.Matchers[last], b, premises))
+		return out
+	case AtLeast, Exactly:
+		counts := make([]int, mask.Len())
+		for _, m := range o.Matchers {
+			matched := matchBatch(m, b, mask)
+			for i := matched.Next(0); i >= 0; i = matched.Next(i + 1) {
+				counts[i]++
+			}
+		}
+		out := NewBitset(mask.Len())
+		for i := mask.Next(0); i >= 0; i = mask.Next(i + 1) {
+			if counts[i] >= o.Count && (o.Type == AtLeast || counts[i] == o.Count) {
+				out.Set(i)
+			}
+		}
+		return out
+	}
+	return NewBitset(mask.Len())
+}
@@ -0,0 +1,197 @@
+package matcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// batchMessages returns n messages of varied hosts, programs, severities and
+// JSON status codes.
+func batchMessages(t testing.TB, n int) []captainslog.SyslogMsg {
+	hosts := []string{"web-1", "web-2", "db-1", "staging-web-1", "cache-3"}
+	programs := []string{"nginx", "cron", "sshd", "postgres"}
+	msgs := make([]captainslog.SyslogMsg, n)
+	for i := range msgs {
+		pri := 8*(i%4) + i%8
+		status := 200 + 100*(i%4)
+		line := fmt.Sprintf(`<%d>Jan  2 15:04:05 %s %s[%d]: {"status": %d}`,
+			pri, hosts[i%len(hosts)], programs[i%len(programs)], i, status)
+		msg, err := captainslog.NewSyslogMsgFromBytes([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.JSONValues = map[string]interface{}{"status": json.Number(fmt.Sprint(status))}
+		msg.IsJSON = true
+		msgs[i] = msg
+	}
+	return msgs
+}
+
+func TestMatchBatch(t *testing.T) {
+	web := NewHostname(PrefixMatch, "web-")
+	cron := NewValue(Program, ExactMatch, "cron")
+	errSev := NewSeverity(LessThanEqual, captainslog.Err)
+	status5xx := NewKV("status", GreaterThanEqual, 500.0)
+	daemon := NewFacility(captainslog.Daemon)
+
+	expired := NewRule("expired", cron)
+	expired.Expires = time.Now().Add(-time.Hour)
+
+	for _, m := range []Matcher{
+		web,
+		NewNAryOp(And, web, cron),
+		NewNAryOp(Or, web, cron, status5xx),
+		NewUnaryOp(Not, NewNAryOp(Or, web, daemon)),
+		NewNAryOp(Xor, web, cron, errSev),
+		NewNAryOp(Implies, web, errSev, status5xx),
+		NewNAryOp(Implies),
+		NewThresholdOp(AtLeast, 2, web, cron, errSev, status5xx),
+		NewThresholdOp(Exactly, 1, web, cron, errSev),
+		NewThresholdOp(Exactly, 0, web, cron),
+		NewNAryOp(And, NewConstant(true), NewNAryOp(Or, NewConstant(false), daemon)),
+		NewRule("cron-errors", NewNAryOp(And, cron, errSev)),
+		expired,
+		NewRef("web", web),
+		Adapt(NewNAryOp(Or, web, cron, NewNAryOp(And, errSev, status5xx)), 4),
+		NewCapture(NewField(ContentField, ""), `"status": (?P<code>\d+)`, "code", GreaterThan, 300.0),
+		NewHostname(Equals, "web-1"),
+		NewHostname(ExactMatch, "web-1"),
+		NewHostname(Contains, "web"),
+		NewHostname(Regex, "^(db|cache)-[0-9]$"),
+		NewHostname(Regex, "("),
+		NewHostname(LessThan, "web-1"),
+		NewValue(Program, Equals, "sshd"),
+		NewValue(Content, Contains, "400"),
+		NewValue(Content, Regex, `"status": [45]`),
+		NewValue(Content, PrefixMatch, "{"),
+		NewSeverity(Equals, captainslog.Warning),
+		NewSeverity(LessThan, captainslog.Warning),
+		NewSeverity(GreaterThan, captainslog.Warning),
+		NewSeverity(GreaterThanEqual, captainslog.Warning),
+		NewSeverity(Contains, captainslog.Warning),
+		NewKV("status", LessThan, 300.0),
+		NewKV("status", Regex, "^[45]"),
+		NewKV("status", Regex, "("),
+		NewKV("status", Equals, true),
+		NewKV("missing", Equals, 200.0),
+		&KV{Key: "status", MatchType: Regex, Value: "^5", Coercion: NumberToString},
+	} {
+		for _, n := range []int{0, 1, 63, 64, 150} {
+			msgs := batchMessages(t, n)
+			got := MatchBatch(m, msgs)
+			if want, got := n, got.Len(); want != got {
+				t.Errorf("%s: want != got, want = %v, got = %v", m, want, got)
+			}
+			for i, msg := range msgs {
+				if want, got := m.Matches(msg), got.Test(i); want != got {
+					t.Errorf("%s: message %d: want != got, want = %v, got = %v", m, i, want, got)
+				}
+			}
+		}
+	}
+
+	rules := Matchers{NewNAryOp(And, web, cron), status5xx, expired}
+	msgs := batchMessages(t, 150)
+	got := rules.MatchBatch(msgs)
+	for i, msg := range msgs {
+		want := false
+		for _, r := range rules {
+			want = want || r.Matches(msg)
+		}
+		if want != got.Test(i) {
+			t.Errorf("message %d: want != got, want = %v, got = %v", i, want, got.Test(i))
+		}
+	}
+
+	op := NewNAryOp(And, web, cron)
+	if want, got := MatchBatch(op, msgs).String(), op.MatchBatch(msgs).String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestBitset(t *testing.T) {
+	b := NewBitset(130)
+	for _, i := range []int{0, 5, 63, 64, 129} {
+		b.Set(i)
+	}
+	if want, got := 5, b.Count(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := fmt.Sprint([]int{0, 5, 63, 64, 129}), fmt.Sprint(b.Indexes()); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := 63, b.Next(6); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := -1, b.Next(130); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	c := b.Clone()
+	c.Clear(5)
+	if !b.Test(5) || c.Test(5) {
+		t.Errorf("want clone to be independent")
+	}
+
+	all := NewBitset(130)
+	all.Fill()
+	if want, got := 130, all.Count(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	all.AndNot(b)
+	if want, got := 125, all.Count(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	all.Or(c)
+	all.Xor(b)
+	if want, got := 126, all.Count(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	all.And(c)
+	if !all.Empty() {
+		t.Errorf("want empty, got %v", all.Indexes())
+	}
+	if want, got := "1011", func() string {
+		s := NewBitset(4)
+		s.Fill()
+		s.Clear(1)
+		return s.String()
+	}(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+// leafRule returns a rule of the leaf matchers compiled by MatchBatch, which
+// evaluates every leaf for most of the messages of batchMessages.
+func leafRule() Matcher {
+	return NewNAryOp(Or,
+		NewNAryOp(And, NewSeverity(LessThanEqual, captainslog.Crit), NewFacility(captainslog.Daemon)),
+		NewNAryOp(And, NewHostname(PrefixMatch, "web-"), NewValue(Program, ExactMatch, "cron")),
+		NewNAryOp(And, NewValue(Content, Contains, "503"), NewKV("status", GreaterThanEqual, 500.0)),
+		NewHostname(Regex, "^cache-[0-9]+$"),
+	)
+}
+
+func BenchmarkLeavesMatches(b *testing.B) {
+	m := leafRule()
+	msgs := batchMessages(b, 4096)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, msg := range msgs {
+			m.Matches(msg)
+		}
+	}
+}
+
+func BenchmarkLeavesMatchBatch(b *testing.B) {
+	m := leafRule()
+	msgs := batchMessages(b, 4096)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		MatchBatch(m, msgs)
+	}
+}
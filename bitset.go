@@ -0,0 +1,148 @@
+package matcher
+
+import (
+	"math/bits"
+	"strings"
+)
+
+// Bitset is a fixed size set of indexes, such as the indexes of the messages
+// of a batch matched by a rule. Copies of a Bitset share their bits, use
+// Clone for an independent copy.
+type Bitset struct {
+	words []uint64
+	n     int
+}
+
+// NewBitset returns a new empty Bitset of the indexes below n.
+func NewBitset(n int) Bitset {
+	return Bitset{
+		words: make([]uint64, (n+63)/64),
+		n:     n,
+	}
+}
+
+// Len returns the size of the Bitset.
+func (b Bitset) Len() int {
+	return b.n
+}
+
+// Test returns true if the index is in the Bitset.
+func (b Bitset) Test(i int) bool {
+	return b.words[i/64]&(1<<(uint(i)%64)) != 0
+}
+
+// Set adds the index to the Bitset.
+func (b Bitset) Set(i int) {
+	b.words[i/64] |= 1 << (uint(i) % 64)
+}
+
+// Clear removes the index from the Bitset.
+func (b Bitset) Clear(i int) {
+	b.words[i/64] &^= 1 << (uint(i) % 64)
+}
+
+// Fill adds every index to the Bitset.
+func (b Bitset) Fill() {
+	for i := range b.words {
+		b.words[i] = ^uint64(0)
+	}
+	if r := b.n % 64; r != 0 {
+		b.words[len(b.words)-1] = 1<<uint(r) - 1
+	}
+}
+
+// Count returns the number of indexes in the Bitset.
+func (b Bitset) Count() int {
+	n := 0
+	for _, w := range b.words {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// Empty returns true if the Bitset holds no index.
+func (b Bitset) Empty() bool {
+	for _, w := range b.words {
+		if w != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Next returns the first index in the Bitset from i, or -1 if there is none.
+func (b Bitset) Next(i int) int {
+	if i >= b.n {
+		return -1
+	}
+	w := i / 64
+	word := b.words[w] >> (uint(i) % 64)
+	if word != 0 {
+		return i + bits.TrailingZeros64(word)
+	}
+	for w++; w < len(b.words); w++ {
+		if b.words[w] != 0 {
+			return w*64 + bits.TrailingZeros64(b.words[w])
+		}
+	}
+	return -1
+}
+
+// Indexes returns the indexes in the Bitset in ascending order.
+func (b Bitset) Indexes() []int {
+	out := make([]int, 0, b.Count())
+	for i := b.Next(0); i >= 0; i = b.Next(i + 1) {
+		out = append(out, i)
+	}
+	return out
+}
+
+// Clone returns a copy of the Bitset.
+func (b Bitset) Clone() Bitset {
+	return Bitset{
+		words: append([]uint64(nil), b.words...),
+		n:     b.n,
+	}
+}
+
+// And removes the indexes which are not in o from the Bitset.
+func (b Bitset) And(o Bitset) {
+	for i := range b.words {
+		b.words[i] &= o.words[i]
+	}
+}
+
+// Or adds the indexes of o to the Bitset.
+func (b Bitset) Or(o Bitset) {
+	for i := range b.words {
+		b.words[i] |= o.words[i]
+	}
+}
+
+// AndNot removes the indexes of o from the Bitset.
+func (b Bitset) AndNot(o Bitset) {
+	for i := range b.words {
+		b.words[i] &^= o.words[i]
+	}
+}
+
+// Xor toggles the indexes of o in the Bitset.
+func (b Bitset) Xor(o Bitset) {
+	for i := range b.words {
+		b.words[i] ^= o.words[i]
+	}
+}
+
+// String converts a Bitset to its corresponding string representation, a 1
+// or 0 for each index, e.g. "1011".
+func (b Bitset) String() string {
+	var s strings.Builder
+	for i := 0; i < b.n; i++ {
+		if b.Test(i) {
+			s.WriteByte('1')
+		} else {
+			s.WriteByte('0')
+		}
+	}
+	return s.String()
+}
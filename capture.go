@@ -0,0 +1,247 @@
+package matcher
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// Capture represents a matcher which extracts a number or duration from a
+// string field using a regular expression capture group, and compares it with
+// a constant.
+type Capture struct {
+	Field   Field
+	Pattern string
+	// Group is the name of the capture group holding the value. If empty,
+	// the first capture group is used.
+	Group     string
+	MatchType MatchType
+	// Value is a float64 for numeric comparisons, or a time.Duration for
+	// duration comparisons, in which case the captured text must be a
+	// duration accepted by time.ParseDuration, e.g. "1532ms".
+	Value interface{}
+
+	// re is the compiled Pattern, set by NewCapture and Decode.
+	re *regexp.Regexp
+}
+
+// NewCapture returns a new Capture extracting the named group of the pattern
+// from the specified field. An int value is converted to a float64.
+func NewCapture(f Field, pattern string, group string, m MatchType, v interface{}) *Capture {
+	if reflect.TypeOf(v).Kind() == reflect.Int {
+		v = float64(reflect.ValueOf(v).Int())
+	}
+
+	re, _ := regexp.Compile(pattern)
+
+	return &Capture{
+		Field:     f,
+		Pattern:   pattern,
+		Group:     group,
+		MatchType: m,
+		Value:     v,
+		re:        re,
+	}
+}
+
+// String converts a Capture to its corresponding string representation.
+func (c Capture) String() string {
+	if d, ok := c.Value.(time.Duration); ok {
+		return fmt.Sprintf("capture(%s, %q, %q, %s, duration(%q))", c.Field, c.Pattern, c.Group, c.MatchType, d)
+	}
+	return fmt.Sprintf("capture(%s, %q, %q, %s, %v)", c.Field, c.Pattern, c.Group, c.MatchType, c.Value)
+}
+
+// Matches returns true if the Capture matches the supplied SyslogMsg. It
+// returns false if the pattern does not match, or the captured text cannot be
+// converted.
+func (c *Capture) Matches(m captainslog.SyslogMsg) bool {
+	v, ok := c.Field.value(m)
+	if !ok {
+		return false
+	}
+	s, ok := asString(v, 0)
+	if !ok {
+		return false
+	}
+
+	re := c.re
+	if re == nil {
+		var err error
+		if re, err = regexp.Compile(c.Pattern); err != nil {
+			return false
+		}
+	}
+
+	idx := captureIndex(re, c.Group)
+	if idx < 0 {
+		return false
+	}
+	sub := re.FindStringSubmatch(s)
+	if sub == nil {
+		return false
+	}
+	text := strings.TrimSpace(sub[idx])
+
+	switch comp := c.Value.(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return false
+		}
+		return compareFloat(c.MatchType, float64(d), float64(comp))
+	case float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return false
+		}
+		return compareFloat(c.MatchType, f, comp)
+	}
+
+	return false
+}
+
+// captureIndex returns the index of the named capture group, or of the first
+// capture group if name is empty. It returns -1 if there is no such group.
+func captureIndex(re *regexp.Regexp, name string) int {
+	if name == "" {
+		if re.NumSubexp() == 0 {
+			return -1
+		}
+		return 1
+	}
+	return re.SubexpIndex(name)
+}
+
+// Decode decodes a matcher map into a Capture type.
+func (c *Capture) Decode(m map[string]interface{}) error {
+	foundField := false
+	foundPattern := false
+	foundMatchType := false
+	foundValue := false
+	for k, v := range m {
+		switch k {
+		case "field":
+			foundField = true
+
+			if f, ok := v.(map[string]interface{}); ok {
+				if err := c.Field.Decode(f); err != nil {
+					return err
+				}
+			} else {
+				return fmt.Errorf("failed to decode capture matcher, field is not a map")
+			}
+		case "pattern":
+			foundPattern = true
+
+			if p, ok := v.(string); ok {
+				c.Pattern = p
+			} else {
+				return fmt.Errorf("failed to decode capture matcher, pattern is not a string")
+			}
+		case "group":
+			if g, ok := v.(string); ok {
+				c.Group = g
+			} else if v != nil {
+				return fmt.Errorf("failed to decode capture matcher, group is not a string")
+			}
+		case "match_type":
+			foundMatchType = true
+
+			if mt, ok := v.(string); ok {
+				if err := c.MatchType.FromString(mt); err != nil {
+					return err
+				}
+			} else {
+				return fmt.Errorf("failed to decode capture matcher, match_type is not a string")
+			}
+		case "num_value":
+			if v != nil {
+				val := reflect.ValueOf(v)
+				switch val.Kind() {
+				case reflect.Int, reflect.Int64:
+					foundValue = true
+					c.Value = float64(val.Int())
+				case reflect.Float32, reflect.Float64:
+					foundValue = true
+					c.Value = val.Float()
+				case reflect.String:
+					if x, ok := parseVar(val.String(), NumberVar); ok {
+						foundValue = true
+						c.Value = x
+					}
+				}
+			}
+		case "duration_value":
+			if v != nil {
+				s, ok := v.(string)
+				if !ok {
+					return fmt.Errorf("failed to decode capture matcher, duration_value is not a string")
+				}
+				if x, ok := parseVar(s, DurationVar); ok {
+					foundValue = true
+					c.Value = x
+					break
+				}
+				d, err := time.ParseDuration(s)
+				if err != nil {
+					return err
+				}
+				foundValue = true
+				c.Value = d
+			}
+		}
+	}
+
+	if !(foundField && foundPattern && foundMatchType && foundValue) {
+		return fmt.Errorf("failed to decode capture matcher, missing fields")
+	}
+
+	if varPattern.MatchString(c.Pattern) {
+		// A template pattern is compiled by Bind.
+		return nil
+	}
+
+	re, err := regexp.Compile(c.Pattern)
+	if err != nil {
+		return err
+	}
+	if captureIndex(re, c.Group) < 0 {
+		return fmt.Errorf("failed to decode capture matcher, pattern has no group %q", c.Group)
+	}
+	c.re = re
+
+	return nil
+}
+
+// Encode encodes a Capture into a matcher map.
+func (c *Capture) Encode(out map[string]interface{}) {
+	field := make(map[string]interface{})
+	c.Field.Encode(field)
+
+	out["field"] = field
+	out["pattern"] = c.Pattern
+	out["group"] = c.Group
+	out["match_type"] = c.MatchType.String()
+
+	out["num_value"] = nil
+	out["duration_value"] = nil
+
+	switch v := c.Value.(type) {
+	case time.Duration:
+		out["duration_value"] = v.String()
+	case float64:
+		out["num_value"] = v
+	case Var:
+		if v.Type == DurationVar {
+			out["duration_value"] = v.String()
+		} else {
+			out["num_value"] = v.String()
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	matcher "github.com/digitalocean/logmatcher"
+	"github.com/digitalocean/logmatcher/internal/message"
+)
+
+// runCoverage runs the coverage subcommand, which reports how often the rules
+// and their clauses matched a corpus of messages, and returns its exit status:
+// 0 if every rule matched, 1 if a rule never matched and 2 if an error
+// occurred.
+func runCoverage(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("logmatch coverage", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: logmatch coverage -rules path [flags] [file ...]\n\n")
+		fs.PrintDefaults()
+	}
+
+	rulesPath := fs.String("rules", "", "rule `path`, a YAML or JSON rule file or a directory of rule files")
+	listsDir := fs.String("lists", "", "`directory` of the lists of list matchers")
+	output := fs.String("o", "text", "output `format`, text or json")
+	templateVars := vars{}
+	fs.Var(templateVars, "var", "template variable as `name=value`, may be repeated")
+
+	if err := fs.Parse(args); err != nil {
+		return exitWithErrors
+	}
+	if *rulesPath == "" {
+		fmt.Fprintf(stderr, "logmatch: -rules is required\n")
+		fs.Usage()
+		return exitWithErrors
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(stderr, "logmatch: unknown output format %q\n", *output)
+		return exitWithErrors
+	}
+
+	rules, err := loadRules(*rulesPath, *listsDir, matcher.Vars(templateVars))
+	if err != nil {
+		fmt.Fprintf(stderr, "logmatch: %v\n", err)
+		return exitWithErrors
+	}
+
+	c := matcher.NewCoverage(rules)
+	observe := func(name string, n int, line []byte) {
+		msg, err := message.Parse(line)
+		if err != nil {
+			fmt.Fprintf(stderr, "logmatch: %s:%d: %v\n", name, n, err)
+			return
+		}
+		c.Observe(msg)
+	}
+
+	status := 0
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, name := range files {
+		if err := readLines(name, stdin, observe); err != nil {
+			fmt.Fprintf(stderr, "logmatch: %v\n", err)
+			status = exitWithErrors
+		}
+	}
+
+	if *output == "json" {
+		b, err := json.MarshalIndent(c, "", "  ")
+		if err != nil {
+			fmt.Fprintf(stderr, "logmatch: %v\n", err)
+			return exitWithErrors
+		}
+		fmt.Fprintf(stdout, "%s\n", b)
+	} else {
+		fmt.Fprintln(stdout, c)
+	}
+
+	if status == 0 && len(c.Never()) > 0 {
+		status = 1
+	}
+	return status
+}
@@ -0,0 +1,260 @@
+// Command logmatch filters syslog messages with logmatcher rules.
+//
+// It reads RFC 3164 and RFC 5424 messages, one per line, from the named files
+// or standard input, and prints the messages matched by any of the rules of a
+// rule file or directory:
+//
+//	logmatch -rules rules.yaml /var/log/syslog
+//	logmatch -rules rules/ -v -o json < messages.log
+//	logmatch -rules rules.yaml -var region=nyc3 -c /var/log/syslog
+//
+// The exit status is 0 if a message was selected, 1 if none was, and 2 if an
+// error occurred.
+//
+// The test subcommand runs the rule test suites described in package
+// matchertest, and exits with status 1 if a case fails:
+//
+//	logmatch test rules_test.yaml
+//
+// The coverage subcommand reports how often every rule, and every clause of
+// the rules, matched the messages, to find rules which never fire:
+//
+//	logmatch coverage -rules rules/ -o json /var/log/syslog
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/digitalocean/captainslog"
+	matcher "github.com/digitalocean/logmatcher"
+	"github.com/digitalocean/logmatcher/internal/message"
+)
+
+// Exit statuses, as used by grep.
+const (
+	exitSelected   = 0
+	exitNoneFound  = 1
+	exitWithErrors = 2
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// vars is a flag.Value collecting name=value template variables.
+type vars matcher.Vars
+
+func (v vars) String() string {
+	return ""
+}
+
+func (v vars) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return errors.New("variable is not name=value")
+	}
+	v[name] = value
+	return nil
+}
+
+// options are the command line options of a filter run.
+type options struct {
+	invert bool
+	count  bool
+	output string
+}
+
+// run runs logmatch with the supplied arguments and returns its exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "test":
+			return runTest(args[1:], stdout, stderr)
+		case "coverage":
+			return runCoverage(args[1:], stdin, stdout, stderr)
+		}
+	}
+
+	fs := flag.NewFlagSet("logmatch", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: logmatch -rules path [flags] [file ...]\n")
+		fmt.Fprintf(stderr, "       logmatch test [-v] suite.yaml ...\n")
+		fmt.Fprintf(stderr, "       logmatch coverage -rules path [flags] [file ...]\n\n")
+		fs.PrintDefaults()
+	}
+
+	var o options
+	rulesPath := fs.String("rules", "", "rule `path`, a YAML or JSON rule file or a directory of rule files")
+	listsDir := fs.String("lists", "", "`directory` of the lists of list matchers")
+	fs.BoolVar(&o.invert, "v", false, "select messages not matched by any rule")
+	fs.BoolVar(&o.count, "c", false, "print only the number of selected messages")
+	fs.StringVar(&o.output, "o", "raw", "output `format`, raw or json")
+	templateVars := vars{}
+	fs.Var(templateVars, "var", "template variable as `name=value`, may be repeated")
+
+	if err := fs.Parse(args); err != nil {
+		return exitWithErrors
+	}
+	if *rulesPath == "" {
+		fmt.Fprintf(stderr, "logmatch: -rules is required\n")
+		fs.Usage()
+		return exitWithErrors
+	}
+	if o.output != "raw" && o.output != "json" {
+		fmt.Fprintf(stderr, "logmatch: unknown output format %q\n", o.output)
+		return exitWithErrors
+	}
+
+	rules, err := loadRules(*rulesPath, *listsDir, matcher.Vars(templateVars))
+	if err != nil {
+		fmt.Fprintf(stderr, "logmatch: %v\n", err)
+		return exitWithErrors
+	}
+
+	f := &filter{options: o, rules: rules, stdout: bufio.NewWriter(stdout), stderr: stderr}
+	defer f.stdout.Flush()
+
+	status := exitNoneFound
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, name := range files {
+		if err := f.file(name, stdin); err != nil {
+			fmt.Fprintf(stderr, "logmatch: %v\n", err)
+			status = exitWithErrors
+		}
+	}
+
+	if o.count {
+		fmt.Fprintln(f.stdout, f.selected)
+	}
+	if status == exitWithErrors {
+		return status
+	}
+	if f.selected > 0 {
+		return exitSelected
+	}
+	return status
+}
+
+// loadRules loads the rules at path, resolves their lists and binds their
+// template variables.
+func loadRules(path, listsDir string, v matcher.Vars) (matcher.Matchers, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules matcher.Matchers
+	if fi.IsDir() {
+		rules, err = matcher.LoadDir(path)
+	} else {
+		rules, err = matcher.LoadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lists *matcher.Lists
+	if listsDir != "" {
+		lists = matcher.NewLists(matcher.NewFileListProvider(listsDir))
+	}
+	for i, r := range rules {
+		if r, err = matcher.Bind(r, v); err != nil {
+			return nil, err
+		}
+		if lists != nil {
+			if err := lists.Resolve(r); err != nil {
+				return nil, err
+			}
+		}
+		rules[i] = r
+	}
+	return rules, nil
+}
+
+// filter selects the messages of its inputs.
+type filter struct {
+	options
+	rules    matcher.Matchers
+	stdout   *bufio.Writer
+	stderr   io.Writer
+	selected int
+}
+
+// file filters the messages of the named file, or of stdin if the name is
+// "-".
+func (f *filter) file(name string, stdin io.Reader) error {
+	return readLines(name, stdin, f.line)
+}
+
+// readLines calls fn with every non-empty line of the named file, or of stdin
+// if the name is "-".
+func readLines(name string, stdin io.Reader, fn func(name string, n int, line []byte)) error {
+	r := stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	} else {
+		name = "(standard input)"
+	}
+
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if line = bytes.TrimRight(line, "\r\n"); len(line) > 0 {
+			fn(name, n, line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// line filters a single message. Messages which fail to parse are reported
+// and never selected.
+func (f *filter) line(name string, n int, line []byte) {
+	msg, err := message.Parse(line)
+	if err != nil {
+		fmt.Fprintf(f.stderr, "logmatch: %s:%d: %v\n", name, n, err)
+		return
+	}
+	if f.matches(msg) == f.invert {
+		return
+	}
+
+	f.selected++
+	if f.count {
+		return
+	}
+	if f.output == "json" {
+		b, err := msg.JSON()
+		if err != nil {
+			fmt.Fprintf(f.stderr, "logmatch: %s:%d: %v\n", name, n, err)
+			return
+		}
+		line = b
+	}
+	f.stdout.Write(line)
+	f.stdout.WriteByte('\n')
+}
+
+// matches returns true if any rule matches the message.
+func (f *filter) matches(msg captainslog.SyslogMsg) bool {
+	return f.rules.Matches(msg)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testRules = `
+- value_matcher:
+    type: program
+    match_type: exact_match
+    value: cron
+- kv_matcher:
+    key: status
+    match_type: gte
+    num_value: ${min_status}
+`
+
+var testInput = strings.Join([]string{
+	`<30>Jan  2 15:04:05 web-1 cron[12]: job ran`,
+	`<30>Jan  2 15:04:05 web-1 nginx[3]: {"status": 502}`,
+	`<30>Jan  2 15:04:05 web-1 nginx[3]: {"status": 200}`,
+	`<34>1 2003-10-11T22:14:15.003Z web-2 cron 99 - [meta a="x]y"] ran`,
+	`garbage`,
+}, "\n") + "\n"
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	rules := writeFile(t, "rules.yaml", testRules)
+	input := writeFile(t, "messages.log", testInput)
+
+	for _, tc := range []struct {
+		args   []string
+		stdin  string
+		status int
+		want   string
+	}{
+		{
+			[]string{"-rules", rules, "-var", "min_status=500", input},
+			"",
+			exitSelected,
+			"<30>Jan  2 15:04:05 web-1 cron[12]: job ran\n" +
+				"<30>Jan  2 15:04:05 web-1 nginx[3]: {\"status\": 502}\n" +
+				"<34>1 2003-10-11T22:14:15.003Z web-2 cron 99 - [meta a=\"x]y\"] ran\n",
+		},
+		{
+			[]string{"-rules", rules, "-var", "min_status=500", "-v"},
+			testInput,
+			exitSelected,
+			"<30>Jan  2 15:04:05 web-1 nginx[3]: {\"status\": 200}\n",
+		},
+		{
+			[]string{"-rules", rules, "-var", "min_status=100", "-c", input, "-"},
+			testInput,
+			exitSelected,
+			"8\n",
+		},
+		{
+			[]string{"-rules", rules, "-var", "min_status=600", "-v", "-c"},
+			"<30>Jan  2 15:04:05 web-1 cron[12]: job ran\n",
+			exitNoneFound,
+			"0\n",
+		},
+	} {
+		var stdout, stderr bytes.Buffer
+		status := run(tc.args, strings.NewReader(tc.stdin), &stdout, &stderr)
+		if tc.status != status {
+			t.Errorf("%v: want status %d, got %d: %s", tc.args, tc.status, status, stderr.String())
+		}
+		if got := stdout.String(); tc.want != got {
+			t.Errorf("%v: want != got, want =\n%v\ngot =\n%v", tc.args, tc.want, got)
+		}
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	rules := writeFile(t, "rules.yaml", testRules)
+
+	var stdout, stderr bytes.Buffer
+	status := run([]string{"-rules", rules, "-var", "min_status=500", "-o", "json"}, strings.NewReader(testInput), &stdout, &stderr)
+	if status != exitSelected {
+		t.Fatalf("want status %d, got %d: %s", exitSelected, status, stderr.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if want, got := 3, len(lines); want != got {
+		t.Fatalf("want != got, want = %v, got = %v", want, got)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[2]), &msg); err != nil {
+		t.Fatalf("failed to unmarshal output: %v", err)
+	}
+	for k, want := range map[string]interface{}{
+		"syslog_host":        "web-2",
+		"syslog_programname": "cron",
+		"syslog_pid":         "99",
+		"syslog_content":     "ran",
+	} {
+		if got := msg[k]; want != got {
+			t.Errorf("%s: want != got, want = %v, got = %v", k, want, got)
+		}
+	}
+
+	if want, got := "logmatch: (standard input):5: Priority not found\n", stderr.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	rules := writeFile(t, "rules.yaml", testRules)
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{}, "-rules is required"},
+		{[]string{"-rules", rules}, `unbound variable "min_status"`},
+		{[]string{"-rules", rules, "-var", "min_status=high"}, `variable "min_status" is not a number`},
+		{[]string{"-rules", rules, "-var", "min_status=1", "-o", "xml"}, `unknown output format "xml"`},
+		{[]string{"-rules", rules, "-var", "min_status=1", "missing.log"}, "missing.log"},
+	} {
+		var stdout, stderr bytes.Buffer
+		if status := run(tc.args, strings.NewReader(""), &stdout, &stderr); status != exitWithErrors {
+			t.Errorf("%v: want status %d, got %d", tc.args, exitWithErrors, status)
+		}
+		if !strings.Contains(stderr.String(), tc.want) {
+			t.Errorf("%v: want error containing %q, got %q", tc.args, tc.want, stderr.String())
+		}
+	}
+}
+
+func TestRunTest(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	status := run([]string{"test", "../../matchertest/testdata/pass.yaml"}, nil, &stdout, &stderr)
+	if status != 0 {
+		t.Errorf("want status 0, got %d: %s", status, stdout.String())
+	}
+	if want, got := "ok\t../../matchertest/testdata/pass.yaml\t3 cases\n", stdout.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	stdout.Reset()
+	status = run([]string{"test", "../../matchertest/testdata/pass.yaml", "../../matchertest/testdata/fail.yaml"}, nil, &stdout, &stderr)
+	if status != 1 {
+		t.Errorf("want status 1, got %d", status)
+	}
+	out := stdout.String()
+	for _, want := range []string{
+		"--- FAIL: ../../matchertest/testdata/fail.yaml:5: production cron is dropped: want rule \"drop-staging-cron\" to match, got no match\n" +
+			"    no match  rule(\"drop-staging-cron\")\n",
+		"FAIL\t../../matchertest/testdata/fail.yaml\t4 of 4 cases failed\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want output containing %q, got:\n%s", want, out)
+		}
+	}
+
+	stdout.Reset()
+	if status := run([]string{"test", "missing.yaml"}, nil, &stdout, &stderr); status != exitWithErrors {
+		t.Errorf("want status %d, got %d", exitWithErrors, status)
+	}
+}
+
+func TestRunCoverage(t *testing.T) {
+	rules := writeFile(t, "rules.yaml", testRules)
+	input := writeFile(t, "messages.log", testInput)
+
+	var stdout, stderr bytes.Buffer
+	status := run([]string{"coverage", "-rules", rules, "-var", "min_status=600", input}, nil, &stdout, &stderr)
+	if status != 1 {
+		t.Errorf("want status 1, got %d: %s", status, stderr.String())
+	}
+	want := "4 messages, 1 of 2 rules and 1 of 2 clauses never matched\n" +
+		"rule 0 program(exact_match, \"cron\"): evaluated 4, matched 2\n" +
+		"rule 1 kv(\"status\", gte, 600): evaluated 4, matched 0, never matched\n"
+	if got := stdout.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	stdout.Reset()
+	status = run([]string{"coverage", "-rules", rules, "-var", "min_status=500", "-o", "json", input}, nil, &stdout, &stderr)
+	if status != 0 {
+		t.Errorf("want status 0, got %d", status)
+	}
+	var got struct {
+		Messages int
+		Rules    []struct {
+			Matched int
+			Never   bool
+		}
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("failed to unmarshal coverage: %v", err)
+	}
+	if want, got := 4, got.Messages; want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if len(got.Rules) != 2 || got.Rules[1].Matched != 1 || got.Rules[1].Never {
+		t.Errorf("want rule 1 to match once, got %+v", got.Rules)
+	}
+}
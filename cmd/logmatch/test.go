@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/digitalocean/logmatcher/matchertest"
+)
+
+// runTest runs the test subcommand, which runs matchertest suites, and
+// returns its exit status: 0 if every case passed, 1 if a case failed and 2
+// if a suite could not be run.
+func runTest(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("logmatch test", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: logmatch test [-v] suite.yaml ...\n\n")
+		fs.PrintDefaults()
+	}
+	verbose := fs.Bool("v", false, "print every case, not only failures")
+
+	if err := fs.Parse(args); err != nil {
+		return exitWithErrors
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitWithErrors
+	}
+
+	status := 0
+	for _, path := range fs.Args() {
+		s, err := matchertest.Load(path)
+		if err != nil {
+			fmt.Fprintf(stdout, "FAIL\t%s\t%v\n", path, err)
+			status = exitWithErrors
+			continue
+		}
+		results, err := s.Run()
+		if err != nil {
+			fmt.Fprintf(stdout, "FAIL\t%s\t%v\n", path, err)
+			status = exitWithErrors
+			continue
+		}
+
+		failed := 0
+		for _, r := range results {
+			if r.Passed() {
+				if *verbose {
+					fmt.Fprintf(stdout, "--- PASS: %s:%d: %s\n", path, r.Case.Line, r.Case)
+				}
+				continue
+			}
+			failed++
+			lines := strings.Split(strings.TrimRight(r.String(), "\n"), "\n")
+			fmt.Fprintf(stdout, "--- FAIL: %s:%d: %s\n", path, r.Case.Line, lines[0])
+			for _, l := range lines[1:] {
+				fmt.Fprintf(stdout, "    %s\n", l)
+			}
+		}
+
+		if failed > 0 {
+			fmt.Fprintf(stdout, "FAIL\t%s\t%d of %d cases failed\n", path, failed, len(results))
+			if status == 0 {
+				status = 1
+			}
+		} else {
+			fmt.Fprintf(stdout, "ok\t%s\t%d cases\n", path, len(results))
+		}
+	}
+	return status
+}
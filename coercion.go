@@ -0,0 +1,181 @@
+package matcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Coercion is a set of type conversions a KV matcher may apply to a JSON
+// value whose type differs from the type of the rule value.
+type Coercion int
+
+// Coercion types. These are bit flags and may be combined, e.g.
+// StringToNumber|StringToBool.
+const (
+	// StringToNumber converts a JSON string to a number for numeric rules.
+	// The string, with surrounding whitespace removed, must be accepted by
+	// strconv.ParseFloat and must not be NaN or infinite.
+	StringToNumber Coercion = 1 << iota
+
+	// StringToBool converts a JSON string to a bool for bool rules. The
+	// string, with surrounding whitespace removed, must be accepted by
+	// strconv.ParseBool, i.e. one of 1, t, T, TRUE, true, True, 0, f, F,
+	// FALSE, false or False.
+	StringToBool
+
+	// NumberToString converts a JSON number to a string for string rules.
+	// The number is formatted in the shortest decimal representation without
+	// an exponent, e.g. 500 is "500" and 0.25 is "0.25". A json.Number is
+	// always compared in its literal form, with or without this flag.
+	NumberToString
+)
+
+// coercions lists the individual Coercion flags in encoding order.
+var coercions = []Coercion{StringToNumber, StringToBool, NumberToString}
+
+// String converts a Coercion to its corresponding string representation,
+// which is a comma separated list for combined flags.
+func (c Coercion) String() string {
+	var s []string
+	for _, f := range coercions {
+		if c&f == 0 {
+			continue
+		}
+		switch f {
+		case StringToNumber:
+			s = append(s, "string_to_number")
+		case StringToBool:
+			s = append(s, "string_to_bool")
+		case NumberToString:
+			s = append(s, "number_to_string")
+		}
+	}
+	if len(s) == 0 {
+		return "none"
+	}
+	return strings.Join(s, ",")
+}
+
+// FromString adds the Coercion corresponding to the supplied string
+// representation to the set.
+func (c *Coercion) FromString(s string) error {
+	switch s {
+	case "string_to_number":
+		*c |= StringToNumber
+	case "string_to_bool":
+		*c |= StringToBool
+	case "number_to_string":
+		*c |= NumberToString
+	case "none":
+	default:
+		return fmt.Errorf("failed to convert string to Coercion")
+	}
+
+	return nil
+}
+
+// Decode decodes a list of coercion names into the Coercion.
+func (c *Coercion) Decode(v interface{}) error {
+	var list []interface{}
+	switch v := v.(type) {
+	case []interface{}:
+		list = v
+	case []string:
+		for _, s := range v {
+			list = append(list, s)
+		}
+	default:
+		return fmt.Errorf("failed to decode coercion, value is not a slice")
+	}
+
+	*c = 0
+	for _, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return fmt.Errorf("failed to decode coercion, found item that wasn't a string")
+		}
+		if err := c.FromString(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Encode encodes the Coercion into a list of coercion names.
+func (c Coercion) Encode() []string {
+	var out []string
+	for _, f := range coercions {
+		if c&f != 0 {
+			out = append(out, f.String())
+		}
+	}
+	return out
+}
+
+// asString returns v as a string, converting numbers if c allows it. A
+// json.Number is a string and is always returned as written.
+func asString(v interface{}, c Coercion) (string, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String(), true
+	case reflect.Float64:
+		if c&NumberToString == 0 {
+			return "", false
+		}
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 64), true
+	}
+
+	return "", false
+}
+
+// asFloat returns v as a float64, converting strings if c allows it.
+func asFloat(v interface{}, c Coercion) (float64, bool) {
+	if n, ok := v.(json.Number); ok {
+		f, err := n.Float64()
+		return f, err == nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float64:
+		return rv.Float(), true
+	case reflect.String:
+		if c&StringToNumber == 0 {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(rv.String()), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, false
+		}
+		return f, true
+	}
+
+	return 0, false
+}
+
+// asBool returns v as a bool, converting strings if c allows it.
+func asBool(v interface{}, c Coercion) (bool, bool) {
+	if _, ok := v.(json.Number); ok {
+		return false, false
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), true
+	case reflect.String:
+		if c&StringToBool == 0 {
+			return false, false
+		}
+		b, err := strconv.ParseBool(strings.TrimSpace(rv.String()))
+		return b, err == nil
+	}
+
+	return false, false
+}
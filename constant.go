@@ -0,0 +1,58 @@
+package matcher
+
+import (
+	"fmt"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// Constant represents a matcher which always or never matches. It is mostly
+// produced by Simplify when a rule folds to a constant.
+type Constant struct {
+	Value bool
+}
+
+// NewConstant returns a new Constant with the specified value.
+func NewConstant(v bool) *Constant {
+	return &Constant{
+		Value: v,
+	}
+}
+
+// String converts a Constant to its corresponding string representation.
+func (c Constant) String() string {
+	return fmt.Sprintf("%t", c.Value)
+}
+
+// Matches returns the value of the Constant.
+func (c *Constant) Matches(m captainslog.SyslogMsg) bool {
+	return c.Value
+}
+
+// Decode decodes a matcher map into a Constant type.
+func (c *Constant) Decode(m map[string]interface{}) error {
+	foundValue := false
+	for k, v := range m {
+		switch k {
+		case "value":
+			foundValue = true
+
+			if b, ok := v.(bool); ok {
+				c.Value = b
+			} else {
+				return fmt.Errorf("failed to decode constant matcher, value is not a bool")
+			}
+		}
+	}
+
+	if !foundValue {
+		return fmt.Errorf("failed to decode constant matcher, missing fields")
+	}
+
+	return nil
+}
+
+// Encode encodes a Constant into a matcher map.
+func (c *Constant) Encode(out map[string]interface{}) {
+	out["value"] = c.Value
+}
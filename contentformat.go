@@ -0,0 +1,239 @@
+package matcher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// ContentFormat is the enum class for representing the formats from which a
+// KV matcher extracts key-value pairs.
+type ContentFormat int
+
+// Content formats.
+const (
+	// DefaultContent uses JSONContent, unless replaced by WithContentFormat
+	// or the ContentFormat of a Loader.
+	DefaultContent ContentFormat = iota
+	// JSONContent uses the JSON values parsed by captainslog.
+	JSONContent
+	// LogfmtContent parses the message content as logfmt key=value pairs.
+	LogfmtContent
+	// AutoContent uses JSONContent for JSON messages and LogfmtContent for
+	// all other messages.
+	AutoContent
+)
+
+// String converts a ContentFormat to its corresponding string representation.
+func (f ContentFormat) String() string {
+	switch f {
+	case DefaultContent:
+		return "default"
+	case JSONContent:
+		return "json"
+	case LogfmtContent:
+		return "logfmt"
+	case AutoContent:
+		return "auto"
+	default:
+		return "invalid type"
+	}
+}
+
+// FromString converts the ContentFormat to the value corresponding to the
+// supplied string representation.
+func (f *ContentFormat) FromString(s string) error {
+	switch s {
+	case "default":
+		*f = DefaultContent
+	case "json":
+		*f = JSONContent
+	case "logfmt":
+		*f = LogfmtContent
+	case "auto":
+		*f = AutoContent
+	default:
+		return fmt.Errorf("failed to convert string to ContentFormat")
+	}
+
+	return nil
+}
+
+// WithContentFormat returns a copy of the supplied matcher in which every KV
+// matcher with the DefaultContent format uses the specified format instead,
+// selecting the format of a whole rule set.
+func WithContentFormat(m Matcher, f ContentFormat) Matcher {
+	return Transform(m, func(n Matcher) Matcher {
+		kv, ok := n.(*KV)
+		if !ok || kv.Format != DefaultContent {
+			return n
+		}
+		c := *kv
+		c.Format = f
+		return &c
+	})
+}
+
+// logfmt returns true if the format reads the logfmt content of the supplied
+// SyslogMsg.
+func (f ContentFormat) logfmt(m captainslog.SyslogMsg) bool {
+	return f == LogfmtContent || (f == AutoContent && !m.IsJSON)
+}
+
+// values returns the key-value pairs of the supplied SyslogMsg in this format,
+// and whether they were parsed from logfmt. It returns false if the message
+// has no pairs in this format. Logfmt content is parsed by c.
+func (f ContentFormat) values(m captainslog.SyslogMsg, c *logfmtContent) (map[string]interface{}, bool, bool) {
+	switch f {
+	case DefaultContent, JSONContent:
+		return m.JSONValues, false, m.IsJSON
+	case LogfmtContent:
+		return c.values(m.Content), true, true
+	case AutoContent:
+		if m.IsJSON {
+			return m.JSONValues, false, true
+		}
+		return c.values(m.Content), true, true
+	}
+
+	return nil, false, false
+}
+
+// ParseLogfmt parses logfmt key-value pairs from the supplied string.
+//
+// Pairs are separated by whitespace. A value is either a bare word ending at
+// the next whitespace, or a double-quoted string which may contain whitespace
+// and Go escape sequences such as \" and \n. A key without a value, e.g.
+// "debug" in "debug level=info", has the value true. Text which does not form
+// a key is skipped. All other values are strings.
+func ParseLogfmt(s string) map[string]interface{} {
+	out := make(map[string]interface{})
+
+	i := 0
+	for i < len(s) {
+		for i < len(s) && isLogfmtSpace(s[i]) {
+			i++
+		}
+
+		start := i
+		for i < len(s) && !isLogfmtSpace(s[i]) && s[i] != '=' && s[i] != '"' {
+			i++
+		}
+		key := s[start:i]
+
+		if i < len(s) && s[i] == '"' {
+			// A quote is not a valid key, skip the quoted string.
+			_, i = logfmtQuoted(s, i)
+			continue
+		}
+		if i == len(s) || s[i] != '=' {
+			if key != "" {
+				out[key] = true
+			}
+			continue
+		}
+
+		i++
+		var val string
+		if i < len(s) && s[i] == '"' {
+			val, i = logfmtQuoted(s, i)
+		} else {
+			start := i
+			for i < len(s) && !isLogfmtSpace(s[i]) {
+				i++
+			}
+			val = s[start:i]
+		}
+
+		if key != "" {
+			out[key] = val
+		}
+	}
+
+	return out
+}
+
+// logfmtQuoted returns the unquoted string starting at offset i of s and the
+// offset just past it. An unterminated string extends to the end of s, and
+// a string with invalid escapes is returned without unquoting.
+func logfmtQuoted(s string, i int) (string, int) {
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '"':
+			if v, err := strconv.Unquote(s[i : j+1]); err == nil {
+				return v, j + 1
+			}
+			return s[i+1 : j], j + 1
+		}
+	}
+	return s[i+1:], len(s)
+}
+
+// isLogfmtSpace returns true if c separates logfmt pairs.
+func isLogfmtSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// logfmtContent is the logfmt content of a message, parsed at most once per
+// evaluation, when a KV matcher first addresses it. Operators pass it to their
+// matchers, so the content is parsed once no matter how many matchers of a
+// rule, or of a rule set evaluated with Matchers.Matches, address it.
+type logfmtContent struct {
+	parsed bool
+	pairs  map[string]interface{}
+}
+
+// values returns the logfmt pairs of the supplied content, parsing it on the
+// first call. The returned map must not be modified.
+func (c *logfmtContent) values(content string) map[string]interface{} {
+	if !c.parsed {
+		c.pairs = ParseLogfmt(content)
+		c.parsed = true
+	}
+	return c.pairs
+}
+
+// contentMatcher is implemented by the matchers which use, or pass to their
+// matchers, the logfmt content of the current evaluation.
+//
+// The content is nil until a KV matcher first reads it, so evaluations which
+// never do allocate nothing. matchesContent returns the content along with
+// the result, and operators pass it on to their next matcher.
+type contentMatcher interface {
+	matchesContent(m captainslog.SyslogMsg, c *logfmtContent) (bool, *logfmtContent)
+}
+
+// matchContent returns true if the matcher matches the supplied SyslogMsg,
+// evaluating it with the logfmt content of the current evaluation if it uses
+// it, along with the content.
+func matchContent(m Matcher, msg captainslog.SyslogMsg, c *logfmtContent) (bool, *logfmtContent) {
+	if cm, ok := m.(contentMatcher); ok {
+		return cm.matchesContent(msg, c)
+	}
+	return m.Matches(msg), c
+}
+
+// Matches returns true if any of the matchers matches the supplied SyslogMsg.
+// The content of the message is parsed once for all of the matchers.
+func (ms Matchers) Matches(m captainslog.SyslogMsg) bool {
+	var c *logfmtContent
+	for _, v := range ms {
+		var matched bool
+		if matched, c = matchContent(v, m, c); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupLogfmt looks up the key path in flat logfmt pairs. A path with
+// several segments is looked up as the segments joined by periods, so that
+// both request.host and ["request.host"] address the key request.host.
+func lookupLogfmt(values map[string]interface{}, p KeyPath) (interface{}, bool) {
+	v, ok := values[strings.Join(p, ".")]
+	return v, ok
+}
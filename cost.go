@@ -0,0 +1,308 @@
+package matcher
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// Cost returns a static estimate of the relative cost of evaluating a
+// matcher, in arbitrary units where comparing a severity costs 1. The cost of
+// an And or Or operation is its expected cost in declaration order, assuming
+// each of its matchers matches half of the messages, so it is lowered by
+// Reorder.
+func Cost(m Matcher) float64 {
+	switch o := m.(type) {
+	case *Constant:
+		return 0
+	case *Severity, *Facility:
+		return 1
+	case *Timestamp:
+		return 2
+	case *Hostname:
+		return stringCost(o.MatchType, 2)
+	case *Value:
+		if o.Type == Content {
+			return stringCost(o.MatchType, 4)
+		}
+		return stringCost(o.MatchType, 2)
+	case *KV:
+		c := 4.0
+		if o.Format != DefaultContent {
+			// The content is parsed by the first such KV of an evaluation.
+			c = 20
+		}
+		return c + stringCost(o.MatchType, 0)
+	case *FieldCompare:
+		return 6
+	case *Capture:
+		// The pattern is compiled once, but runs on every evaluation.
+		return 30
+	case *List:
+		return 3
+	case *Ref:
+		if o.Matcher == nil {
+			return 0
+		}
+		return Cost(o.Matcher)
+	case *Rule:
+		return 1 + Cost(o.Matcher)
+	case *UnaryOp:
+		return Cost(o.Matcher)
+	case *NAryOp:
+		return naryCost(o.Type, o.Matchers)
+	case *AdaptiveOp:
+		return naryCost(o.Type, o.Children())
+	case Parent:
+		var c float64
+		for _, child := range o.Children() {
+			c += Cost(child)
+		}
+		return c
+	}
+	return 1
+}
+
+// stringCost returns the cost of a string comparison of a field costing base
+// to read. Regexes are compiled on every evaluation.
+func stringCost(t MatchType, base float64) float64 {
+	switch t {
+	case Contains:
+		return base * 2
+	case Regex:
+		return base + 100
+	}
+	return base
+}
+
+// naryCost returns the expected cost of an n-ary operation on the matchers in
+// order.
+func naryCost(t NAryOpType, ms Matchers) float64 {
+	var c float64
+	reached := 1.0
+	for _, m := range ms {
+		c += reached * Cost(m)
+		if t == And || t == Or {
+			reached /= 2
+		}
+	}
+	return c
+}
+
+// Reorder returns a copy of the supplied matcher in which the matchers of
+// every And and Or operation are sorted by their static Cost, so cheap checks
+// can short-circuit expensive ones. The results of the matcher are unchanged.
+func Reorder(m Matcher) Matcher {
+	return Transform(m, func(n Matcher) Matcher {
+		o, ok := n.(*NAryOp)
+		if !ok || (o.Type != And && o.Type != Or) {
+			return n
+		}
+		cs := append(Matchers(nil), o.Matchers...)
+		sort.SliceStable(cs, func(i, j int) bool {
+			return Cost(cs[i]) < Cost(cs[j])
+		})
+		return NewNAryOp(o.Type, cs...)
+	})
+}
+
+// Adapt returns a copy of the supplied matcher in which every And and Or
+// operation is an AdaptiveOp, reordering its matchers every so many
+// evaluations. An every of 0 reorders every 1024 evaluations.
+func Adapt(m Matcher, every uint64) Matcher {
+	if every == 0 {
+		every = 1024
+	}
+	return Transform(m, func(n Matcher) Matcher {
+		o, ok := n.(*NAryOp)
+		if !ok || (o.Type != And && o.Type != Or) {
+			return n
+		}
+		return NewAdaptiveOp(o.Type, every, o.Matchers...)
+	})
+}
+
+// Freeze returns a copy of a matcher returned by Adapt in which every
+// AdaptiveOp is replaced by an NAryOp with its matchers in their current
+// order, e.g. to encode the learned order.
+func Freeze(m Matcher) Matcher {
+	return Transform(m, func(n Matcher) Matcher {
+		if o, ok := n.(*AdaptiveOp); ok {
+			return o.frozen()
+		}
+		return n
+	})
+}
+
+// timingInterval is how often the latency of the matchers of an AdaptiveOp
+// is sampled, in evaluations of the operation.
+const timingInterval = 8
+
+// adaptiveStats are the observed selectivity and latency of a matcher of an
+// AdaptiveOp.
+type adaptiveStats struct {
+	evaluations atomic.Uint64
+	hits        atomic.Uint64
+	timed       atomic.Uint64
+	nanos       atomic.Int64
+}
+
+// AdaptiveOp is an And or Or operation which reorders its matchers by their
+// observed selectivity and cost, so the matchers most likely to
+// short-circuit the operation for the least cost are evaluated first. An And
+// evaluates first the matchers which rarely match, and an Or those which
+// often do. Until every matcher was timed, the static Cost of the matchers is
+// used instead of their latency.
+//
+// The results of the operation never depend on the order, and it is safe for
+// concurrent use. An AdaptiveOp with only NAryOp and Every set starts in the
+// order of NAryOp.
+//
+// Simplify, Analyze, Diff, Equal, Canonical and MatchBatch treat an
+// AdaptiveOp as the NAryOp of its matchers in their current order, as Freeze
+// does.
+type AdaptiveOp struct {
+	*NAryOp
+	// Every is the number of evaluations between reorders.
+	Every uint64
+
+	once        sync.Once
+	stats       []adaptiveStats
+	order       atomic.Pointer[[]int]
+	evaluations atomic.Uint64
+}
+
+// NewAdaptiveOp returns a new AdaptiveOp with the specified operation type,
+// And or Or, reordering its matchers every so many evaluations.
+func NewAdaptiveOp(t NAryOpType, every uint64, v ...Matcher) *AdaptiveOp {
+	o := &AdaptiveOp{
+		NAryOp: NewNAryOp(t, v...),
+		Every:  every,
+	}
+	o.init()
+	return o
+}
+
+// init allocates the statistics of the matchers, and sets their order to the
+// order of NAryOp, unless it was done already.
+func (o *AdaptiveOp) init() {
+	o.once.Do(func() {
+		o.stats = make([]adaptiveStats, len(o.Matchers))
+		order := make([]int, len(o.Matchers))
+		for i := range order {
+			order[i] = i
+		}
+		o.order.Store(&order)
+	})
+}
+
+// frozen returns an NAryOp of the matchers of the AdaptiveOp in their current
+// order.
+func (o *AdaptiveOp) frozen() *NAryOp {
+	return NewNAryOp(o.Type, o.Children()...)
+}
+
+// String converts an AdaptiveOp to the string representation of an NAryOp
+// with its matchers in their current order.
+func (o *AdaptiveOp) String() string {
+	return o.frozen().String()
+}
+
+// Matches returns true if the AdaptiveOp matches the supplied SyslogMsg.
+func (o *AdaptiveOp) Matches(m captainslog.SyslogMsg) bool {
+	matched, _ := o.matchesContent(m, nil)
+	return matched
+}
+
+// matchesContent returns true if the AdaptiveOp matches the supplied
+// SyslogMsg, whose logfmt content is parsed by c, along with c.
+func (o *AdaptiveOp) matchesContent(m captainslog.SyslogMsg, c *logfmtContent) (bool, *logfmtContent) {
+	o.init()
+	n := o.evaluations.Add(1)
+	timed := n%timingInterval == 0
+
+	// An And stops at the first matcher which does not match, an Or at the
+	// first which does.
+	stop := o.Type == Or
+	result := !stop
+	for _, i := range *o.order.Load() {
+		s := &o.stats[i]
+		var start time.Time
+		if timed {
+			start = time.Now()
+		}
+		var matched bool
+		matched, c = matchContent(o.Matchers[i], m, c)
+		if timed {
+			s.nanos.Add(int64(time.Since(start)))
+			s.timed.Add(1)
+		}
+		s.evaluations.Add(1)
+		if matched {
+			s.hits.Add(1)
+		}
+		if matched == stop {
+			result = stop
+			break
+		}
+	}
+
+	if o.Every > 0 && n%o.Every == 0 {
+		o.reorder()
+	}
+	return result, c
+}
+
+// reorder sorts the matchers by the ratio of their cost to the probability
+// that they short-circuit the operation.
+func (o *AdaptiveOp) reorder() {
+	// Latencies are only comparable if every matcher was timed.
+	observed := true
+	for i := range o.stats {
+		if o.stats[i].timed.Load() == 0 {
+			observed = false
+		}
+	}
+
+	rank := make([]float64, len(o.Matchers))
+	for i, m := range o.Matchers {
+		s := &o.stats[i]
+		cost := Cost(m)
+		if observed {
+			cost = float64(s.nanos.Load()) / float64(s.timed.Load())
+		}
+		// The Laplace estimate of the probability of a match.
+		p := (float64(s.hits.Load()) + 1) / (float64(s.evaluations.Load()) + 2)
+		if o.Type == And {
+			p = 1 - p
+		}
+		rank[i] = cost / p
+	}
+
+	order := append([]int(nil), *o.order.Load()...)
+	sort.SliceStable(order, func(i, j int) bool {
+		return rank[order[i]] < rank[order[j]]
+	})
+	o.order.Store(&order)
+}
+
+// Children returns the matchers of the AdaptiveOp in their current order.
+func (o *AdaptiveOp) Children() Matchers {
+	o.init()
+	order := *o.order.Load()
+	cs := make(Matchers, len(order))
+	for i, j := range order {
+		cs[i] = o.Matchers[j]
+	}
+	return cs
+}
+
+// WithChildren returns a new AdaptiveOp on the supplied matchers, which start
+// in the order supplied with no statistics. Use Freeze to replace AdaptiveOps
+// with NAryOps.
+func (o *AdaptiveOp) WithChildren(cs Matchers) Matcher {
+	return NewAdaptiveOp(o.Type, o.Every, cs...)
+}
@@ -0,0 +1,169 @@
+package matcher
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestCost(t *testing.T) {
+	ordered := Matchers{
+		NewConstant(true),
+		NewFacility(captainslog.Cron),
+		NewValue(Program, ExactMatch, "cron"),
+		NewValue(Content, Contains, "error"),
+		NewCapture(NewField(ContentField, ""), `took (?P<ms>\d+)ms`, "ms", GreaterThan, 500.0),
+		NewHostname(Regex, "^web-[0-9]+$"),
+	}
+	for i := 1; i < len(ordered); i++ {
+		if Cost(ordered[i-1]) >= Cost(ordered[i]) {
+			t.Errorf("want cost of %s < cost of %s, got %v >= %v",
+				ordered[i-1], ordered[i], Cost(ordered[i-1]), Cost(ordered[i]))
+		}
+	}
+
+	m := NewNAryOp(And, NewHostname(Regex, "^web-"), NewFacility(captainslog.Cron))
+	r := Reorder(m)
+	want := NewNAryOp(And, NewFacility(captainslog.Cron), NewHostname(Regex, "^web-"))
+	if !reflect.DeepEqual(want, r) {
+		t.Errorf("want != got, want = %v, got = %v", want, r)
+	}
+	if Cost(r) >= Cost(m) {
+		t.Errorf("want reordered cost < %v, got %v", Cost(m), Cost(r))
+	}
+}
+
+func TestAdapt(t *testing.T) {
+	m := NewRule("web-nginx", NewNAryOp(And,
+		NewValue(Program, ExactMatch, "nginx"),
+		NewHostname(PrefixMatch, "web-"),
+	))
+	a := Adapt(m, 64)
+
+	var msgs []captainslog.SyslogMsg
+	for i := 0; i < 1000; i++ {
+		// nginx almost always matches, while web- hosts are rare.
+		host := "db"
+		if i%50 == 0 {
+			host = "web"
+		}
+		line := fmt.Sprintf("<30>Jan  2 15:04:05 %s-%d nginx[3]: GET /", host, i)
+		msg, err := captainslog.NewSyslogMsgFromBytes([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	for _, msg := range msgs {
+		if want, got := m.Matches(msg), a.Matches(msg); want != got {
+			t.Errorf("want != got, want = %v, got = %v", want, got)
+		}
+	}
+
+	want := `rule("web-nginx", (hostname(prefix_match, web-) and program(exact_match, "nginx")))`
+	if got := Freeze(a).String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if got := a.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	out := make(map[string]interface{})
+	Encode(a, out)
+	in := make(map[string]interface{})
+	Encode(Freeze(a), in)
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("want != got, want = %v, got = %v", in, out)
+	}
+}
+
+func TestAdaptiveOpZeroValue(t *testing.T) {
+	o := &AdaptiveOp{NAryOp: NewNAryOp(Or, NewHostname(ExactMatch, "a"), NewHostname(ExactMatch, "b")), Every: 1}
+
+	msg := captainslog.NewSyslogMsg()
+	msg.Host = "b"
+	for i := 0; i < 4; i++ {
+		if want, got := true, o.Matches(msg); want != got {
+			t.Errorf("want != got, want = %v, got = %v", want, got)
+		}
+	}
+	if want, got := `(hostname(exact_match, b) or hostname(exact_match, a))`, o.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestAdaptiveOpAnalysis(t *testing.T) {
+	web := NewHostname(PrefixMatch, "web-")
+	nginx := NewValue(Program, ExactMatch, "nginx")
+	a := Adapt(NewNAryOp(And, web, NewNAryOp(And, nginx, NewUnaryOp(Not, NewUnaryOp(Not, web)))), 0)
+
+	if want, got := NewNAryOp(And, web, nginx).String(), Simplify(a).String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if got := Analyze(Adapt(NewNAryOp(And, web, NewUnaryOp(Not, web)), 0)); !got.Unsatisfiable {
+		t.Errorf("want unsatisfiable, got %+v", got)
+	}
+	if cs := Diff(a, Freeze(a)); len(cs) != 0 {
+		t.Errorf("want no changes, got:\n%s", cs)
+	}
+	want := "~ /1/0: program(exact_match, \"nginx\") -> program(exact_match, \"envoy\")\n"
+	to := Adapt(NewNAryOp(And, web, NewNAryOp(And, NewValue(Program, ExactMatch, "envoy"), NewUnaryOp(Not, NewUnaryOp(Not, web)))), 0)
+	if got := Diff(a, to).String(); want != got {
+		t.Errorf("want != got, want =\n%v\ngot =\n%v", want, got)
+	}
+	if want, got := Canonical(Freeze(a)).String(), Canonical(a).String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestAdaptiveOpTransform(t *testing.T) {
+	web := NewHostname(PrefixMatch, "web-")
+	cron := NewValue(Program, ExactMatch, "cron")
+	a := Adapt(NewRule("r", NewNAryOp(And, web, NewUnaryOp(Not, cron))), 16)
+
+	// adaptive counts the AdaptiveOps of a tree, looking through the
+	// matchers wrapped by Metrics and Coverage.
+	var adaptive func(m Matcher) int
+	adaptive = func(m Matcher) int {
+		switch w := m.(type) {
+		case *timed:
+			m = w.Matcher
+		case *counted:
+			m = w.Matcher
+		}
+		n := 0
+		if o, ok := m.(*AdaptiveOp); ok {
+			n++
+			if want, got := uint64(16), o.Every; want != got {
+				t.Errorf("want != got, want = %v, got = %v", want, got)
+			}
+		}
+		if p, ok := m.(Parent); ok {
+			for _, c := range p.Children() {
+				n += adaptive(c)
+			}
+		}
+		return n
+	}
+
+	for _, m := range []Matcher{
+		a,
+		Transform(a, func(n Matcher) Matcher { return n }),
+		NewMetrics(Matchers{a}).Rules()[0],
+		NewCoverage(Matchers{a}).rules[0],
+		WithContentFormat(a, LogfmtContent),
+	} {
+		if want, got := 1, adaptive(m); want != got {
+			t.Errorf("%s: want != got, want = %v, got = %v", m, want, got)
+		}
+	}
+	if want, got := 0, adaptive(Freeze(a)); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := 0, adaptive(Canonical(a)); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
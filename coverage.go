@@ -0,0 +1,213 @@
+package matcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// NodeCoverage counts how often a node of a rule was evaluated, and how often
+// it matched.
+type NodeCoverage struct {
+	Path      Path
+	Matcher   Matcher
+	Evaluated int
+	Matched   int
+}
+
+// Never returns true if the node never matched.
+func (n NodeCoverage) Never() bool {
+	return n.Matched == 0
+}
+
+// String converts a NodeCoverage to its corresponding string representation.
+func (n NodeCoverage) String() string {
+	s := fmt.Sprintf("%s %s: evaluated %d, matched %d", n.Path, n.Matcher, n.Evaluated, n.Matched)
+	if n.Never() {
+		s += ", never matched"
+	}
+	return s
+}
+
+// MarshalJSON encodes a NodeCoverage with its path and matcher as strings.
+func (n NodeCoverage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Path      string `json:"path"`
+		Matcher   string `json:"matcher"`
+		Evaluated int    `json:"evaluated"`
+		Matched   int    `json:"matched"`
+		Never     bool   `json:"never"`
+	}{n.Path.String(), n.Matcher.String(), n.Evaluated, n.Matched, n.Never()})
+}
+
+// RuleCoverage is the coverage of a rule of a set, and of its leaf clauses.
+type RuleCoverage struct {
+	NodeCoverage
+	// Index is the position of the rule in the set.
+	Index int
+	// ID is the ID of the rule, if it is a Rule.
+	ID string
+	// Clauses are the leaves of the tree of the rule, in the order they
+	// appear in. A rule which is a leaf is its own clause.
+	Clauses []*NodeCoverage
+}
+
+// String converts a RuleCoverage to its corresponding string representation.
+func (r RuleCoverage) String() string {
+	var b strings.Builder
+	if r.ID != "" {
+		fmt.Fprintf(&b, "rule %d %q", r.Index, r.ID)
+	} else {
+		fmt.Fprintf(&b, "rule %d %s", r.Index, r.Matcher)
+	}
+	fmt.Fprintf(&b, ": evaluated %d, matched %d", r.Evaluated, r.Matched)
+	if r.Never() {
+		b.WriteString(", never matched")
+	}
+	for _, c := range r.Clauses {
+		if len(c.Path) == 0 {
+			// The rule is itself the only clause.
+			continue
+		}
+		b.WriteString("\n  ")
+		b.WriteString(c.String())
+	}
+	return b.String()
+}
+
+// MarshalJSON encodes a RuleCoverage with its matcher as a string.
+func (r RuleCoverage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Index     int             `json:"index"`
+		ID        string          `json:"id,omitempty"`
+		Matcher   string          `json:"matcher"`
+		Evaluated int             `json:"evaluated"`
+		Matched   int             `json:"matched"`
+		Never     bool            `json:"never"`
+		Clauses   []*NodeCoverage `json:"clauses"`
+	}{r.Index, r.ID, r.Matcher.String(), r.Evaluated, r.Matched, r.Never(), r.Clauses})
+}
+
+// Coverage measures which rules of a set, and which of their clauses, match a
+// corpus of messages. Rules are evaluated as by Matches, so a clause skipped
+// by a short-circuiting operator is not counted as evaluated. A Coverage is
+// not safe for concurrent use.
+type Coverage struct {
+	Messages int             `json:"messages"`
+	Rules    []*RuleCoverage `json:"rules"`
+
+	rules Matchers
+}
+
+// NewCoverage returns a new Coverage of the supplied rules.
+func NewCoverage(rules Matchers) *Coverage {
+	c := &Coverage{}
+	for i, r := range rules {
+		rc := &RuleCoverage{Index: i}
+		if rule, ok := r.(*Rule); ok {
+			rc.ID = rule.ID
+		}
+		c.Rules = append(c.Rules, rc)
+		c.rules = append(c.rules, instrument(r, Path{}, rc.count))
+	}
+	return c
+}
+
+// counted counts the evaluations and matches of the matcher it wraps.
+type counted struct {
+	Matcher
+	node *NodeCoverage
+}
+
+// Matches returns true if the wrapped matcher matches the supplied SyslogMsg.
+func (c *counted) Matches(m captainslog.SyslogMsg) bool {
+	matched, _ := c.matchesContent(m, nil)
+	return matched
+}
+
+// matchesContent returns true if the wrapped matcher matches the supplied
+// SyslogMsg, whose logfmt content is parsed by lc, along with lc.
+func (c *counted) matchesContent(m captainslog.SyslogMsg, lc *logfmtContent) (bool, *logfmtContent) {
+	c.node.Evaluated++
+	matched, lc := matchContent(c.Matcher, m, lc)
+	if matched {
+		c.node.Matched++
+	}
+	return matched, lc
+}
+
+// count is the instrument hook wrapping every node of the tree of a rule with
+// a counted matcher, recording the leaves as clauses of the rule.
+func (rc *RuleCoverage) count(m Matcher, p Path) func(Matcher) Matcher {
+	n := &rc.NodeCoverage
+	if len(p) != 0 {
+		n = &NodeCoverage{}
+	}
+	n.Path, n.Matcher = p, m
+
+	if _, ok := m.(Parent); !ok {
+		rc.Clauses = append(rc.Clauses, n)
+	}
+	return func(m Matcher) Matcher {
+		return &counted{Matcher: m, node: n}
+	}
+}
+
+// Observe evaluates every rule against the supplied SyslogMsg, and returns
+// true if any of them matched.
+func (c *Coverage) Observe(m captainslog.SyslogMsg) bool {
+	c.Messages++
+	matched := false
+	var lc *logfmtContent
+	for _, r := range c.rules {
+		var ok bool
+		if ok, lc = matchContent(r, m, lc); ok {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// Never returns the rules which never matched.
+func (c *Coverage) Never() []*RuleCoverage {
+	var out []*RuleCoverage
+	for _, r := range c.Rules {
+		if r.Never() {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// NeverClauses returns the clauses of every rule which never matched.
+func (c *Coverage) NeverClauses() []*NodeCoverage {
+	var out []*NodeCoverage
+	for _, r := range c.Rules {
+		for _, n := range r.Clauses {
+			if n.Never() {
+				out = append(out, n)
+			}
+		}
+	}
+	return out
+}
+
+// String converts a Coverage to its corresponding string representation, a
+// summary followed by the coverage of every rule.
+func (c Coverage) String() string {
+	clauses := 0
+	for _, r := range c.Rules {
+		clauses += len(r.Clauses)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d messages, %d of %d rules and %d of %d clauses never matched",
+		c.Messages, len(c.Never()), len(c.Rules), len(c.NeverClauses()), clauses)
+	for _, r := range c.Rules {
+		b.WriteByte('\n')
+		b.WriteString(r.String())
+	}
+	return b.String()
+}
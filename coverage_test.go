@@ -0,0 +1,67 @@
+package matcher
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestCoverage(t *testing.T) {
+	rules := Matchers{
+		NewRule("drop-staging-cron", NewNAryOp(And,
+			NewHostname(PrefixMatch, "staging-"),
+			NewValue(Program, ExactMatch, "cron"),
+		)),
+		NewNAryOp(And,
+			NewValue(Program, ExactMatch, "nginx"),
+			NewUnaryOp(Not, NewHostname(PrefixMatch, "web-")),
+		),
+	}
+
+	c := NewCoverage(rules)
+	for _, line := range []string{
+		"<30>Jan  2 15:04:05 staging-1 cron[12]: job ran",
+		"<30>Jan  2 15:04:05 web-1 cron[12]: job ran",
+		"<30>Jan  2 15:04:05 web-1 sshd[12]: accepted",
+	} {
+		msg, err := captainslog.NewSyslogMsgFromBytes([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Observe(msg)
+	}
+
+	want := `3 messages, 1 of 2 rules and 2 of 4 clauses never matched
+rule 0 "drop-staging-cron": evaluated 3, matched 1
+  /0/0 hostname(prefix_match, staging-): evaluated 3, matched 1
+  /0/1 program(exact_match, "cron"): evaluated 1, matched 1
+rule 1 (program(exact_match, "nginx") and not hostname(prefix_match, web-)): evaluated 3, matched 0, never matched
+  /0 program(exact_match, "nginx"): evaluated 3, matched 0, never matched
+  /1/0 hostname(prefix_match, web-): evaluated 0, matched 0, never matched`
+	if got := c.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	if want, got := 1, len(c.Never()); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := 2, len(c.NeverClauses()); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"messages":3`,
+		`{"index":0,"id":"drop-staging-cron","matcher":"rule(\"drop-staging-cron\", (hostname(prefix_match, staging-) and program(exact_match, \"cron\")))","evaluated":3,"matched":1,"never":false,"clauses":[`,
+		`{"path":"/0","matcher":"program(exact_match, \"nginx\")","evaluated":3,"matched":0,"never":true}`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("want JSON containing %s, got %s", want, b)
+		}
+	}
+}
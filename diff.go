@@ -0,0 +1,312 @@
+package matcher
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChangeType is the enum class for representing the types of changes between
+// two matcher trees.
+type ChangeType int
+
+// Change types.
+const (
+	Added ChangeType = iota
+	Removed
+	Modified
+)
+
+// String converts a ChangeType to its corresponding string representation.
+func (t ChangeType) String() string {
+	switch t {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	default:
+		return "invalid type"
+	}
+}
+
+// Change is a single difference between two matcher trees.
+type Change struct {
+	Type ChangeType
+	// OldPath and Old are the position and node in the old tree, unset for
+	// an added node.
+	OldPath Path
+	Old     Matcher
+	// NewPath and New are the position and node in the new tree, unset for a
+	// removed node.
+	NewPath Path
+	New     Matcher
+}
+
+// String converts a Change to its corresponding string representation.
+func (c Change) String() string {
+	switch c.Type {
+	case Added:
+		return fmt.Sprintf("+ %s: %s", c.NewPath, c.New)
+	case Removed:
+		return fmt.Sprintf("- %s: %s", c.OldPath, c.Old)
+	}
+
+	before, after := c.Old.String(), c.New.String()
+	if o, ok := c.Old.(*NAryOp); ok {
+		if n, ok := c.New.(*NAryOp); ok {
+			// Only the operation of an n-ary op is modified, its matchers
+			// are reported as separate changes.
+			before, after = opString(o), opString(n)
+		}
+	}
+	if o, ok := c.Old.(*Rule); ok {
+		if n, ok := c.New.(*Rule); ok {
+			// Likewise, only the metadata of a rule is modified.
+			before, after = ruleString(o), ruleString(n)
+		}
+	}
+	if c.OldPath.String() == c.NewPath.String() {
+		return fmt.Sprintf("~ %s: %s -> %s", c.NewPath, before, after)
+	}
+	return fmt.Sprintf("~ %s -> %s: %s -> %s", c.OldPath, c.NewPath, before, after)
+}
+
+// opString returns the operation of an NAryOp without its matchers.
+func opString(o *NAryOp) string {
+	if o.Type.IsThreshold() {
+		return fmt.Sprintf("%s(%d)", o.Type, o.Count)
+	}
+	return o.Type.String()
+}
+
+// ruleString returns the ID and metadata of a Rule without its matcher.
+func ruleString(r *Rule) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "rule(%q", r.ID)
+	for _, f := range []struct{ name, value string }{
+		{"name", r.Name},
+		{"description", r.Description},
+		{"owner", r.Owner},
+	} {
+		if f.value != "" {
+			fmt.Fprintf(&b, ", %s=%q", f.name, f.value)
+		}
+	}
+	if len(r.Labels) > 0 {
+		labels := make([]string, 0, len(r.Labels))
+		for k, v := range r.Labels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		fmt.Fprintf(&b, ", labels=%q", strings.Join(labels, ","))
+	}
+	if !r.Created.IsZero() {
+		fmt.Fprintf(&b, ", created=%q", r.Created.Format(time.RFC3339))
+	}
+	if !r.Expires.IsZero() {
+		fmt.Fprintf(&b, ", expires=%q", r.Expires.Format(time.RFC3339))
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// Encode encodes a Change into a map.
+func (c Change) Encode(out map[string]interface{}) {
+	out["type"] = c.Type.String()
+	if c.Old != nil {
+		out["old_path"] = c.OldPath.String()
+		out["old"] = make(map[string]interface{})
+		Encode(c.Old, out["old"].(map[string]interface{}))
+	}
+	if c.New != nil {
+		out["new_path"] = c.NewPath.String()
+		out["new"] = make(map[string]interface{})
+		Encode(c.New, out["new"].(map[string]interface{}))
+	}
+}
+
+// Changes is a slice of Change.
+type Changes []Change
+
+// String converts Changes to their human readable representation, one change
+// per line.
+func (cs Changes) String() string {
+	var b bytes.Buffer
+	for _, c := range cs {
+		b.WriteString(c.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Encode encodes Changes into a slice of maps, e.g. for JSON output.
+func (cs Changes) Encode() []map[string]interface{} {
+	out := make([]map[string]interface{}, len(cs))
+	for i, c := range cs {
+		out[i] = make(map[string]interface{})
+		c.Encode(out[i])
+	}
+	return out
+}
+
+// Diff returns the changes between two matcher trees. Nodes which are Equal
+// are unchanged, so reordering the matchers of a commutative operation is
+// not a change. The matchers of two n-ary operations are paired up by
+// equality first, then by similarity, and the pairs are compared recursively.
+func Diff(from, to Matcher) Changes {
+	var cs Changes
+	diff(from, Path{}, to, Path{}, &cs)
+	return cs
+}
+
+// diff appends the changes between two nodes to cs.
+func diff(from Matcher, fp Path, to Matcher, tp Path, cs *Changes) {
+	if Equal(from, to) {
+		return
+	}
+	// A missing matcher, e.g. of a Rule, is added or removed.
+	switch {
+	case from == nil:
+		*cs = append(*cs, Change{Type: Added, NewPath: tp, New: to})
+		return
+	case to == nil:
+		*cs = append(*cs, Change{Type: Removed, OldPath: fp, Old: from})
+		return
+	}
+	if o, ok := from.(*AdaptiveOp); ok {
+		from = o.frozen()
+	}
+	if n, ok := to.(*AdaptiveOp); ok {
+		to = n.frozen()
+	}
+
+	switch o := from.(type) {
+	case *UnaryOp:
+		if n, ok := to.(*UnaryOp); ok && o.Type == n.Type {
+			diff(o.Matcher, fp.Child(0), n.Matcher, tp.Child(0), cs)
+			return
+		}
+	case *NAryOp:
+		if n, ok := to.(*NAryOp); ok {
+			if o.Type != n.Type || (o.Type.IsThreshold() && o.Count != n.Count) {
+				*cs = append(*cs, Change{Type: Modified, OldPath: fp, Old: from, NewPath: tp, New: to})
+			}
+			diffMatchers(o, fp, n, tp, cs)
+			return
+		}
+	case Parent:
+		// Other parents are compared child by child, the same as implies,
+		// after their own state.
+		if n, ok := to.(Parent); ok && sameType(from, to) && len(o.Children()) == len(n.Children()) {
+			nc := n.Children()
+			before := len(*cs)
+			if !reflect.DeepEqual(ownState(o), ownState(n)) {
+				*cs = append(*cs, Change{Type: Modified, OldPath: fp, Old: from, NewPath: tp, New: to})
+			}
+			for i, c := range o.Children() {
+				diff(c, fp.Child(i), nc[i], tp.Child(i), cs)
+			}
+			if len(*cs) > before {
+				return
+			}
+			// No difference was found in the encodings, so report the
+			// parent as modified.
+		}
+	}
+
+	*cs = append(*cs, Change{Type: Modified, OldPath: fp, Old: from, NewPath: tp, New: to})
+}
+
+// ownState returns the encoding of a parent with its children replaced by
+// placeholders, so parents which only differ in their children are equal.
+func ownState(p Parent) map[string]interface{} {
+	cs := make(Matchers, len(p.Children()))
+	for i := range cs {
+		cs[i] = NewConstant(true)
+	}
+	out := make(map[string]interface{})
+	Encode(p.WithChildren(cs), out)
+	return out
+}
+
+// diffMatchers appends the changes between the matchers of two n-ary
+// operations to cs.
+func diffMatchers(o *NAryOp, fp Path, n *NAryOp, tp Path, cs *Changes) {
+	pairs := make([]int, len(o.Matchers))
+	for i := range pairs {
+		pairs[i] = -1
+	}
+	used := make([]bool, len(n.Matchers))
+
+	if o.Type == Implies || n.Type == Implies {
+		// The order of the matchers of an implies is significant, so they
+		// are paired by position.
+		for i := range o.Matchers {
+			if i < len(n.Matchers) {
+				pairs[i] = i
+				used[i] = true
+			}
+		}
+	} else {
+		for _, match := range []func(a, b Matcher) bool{Equal, similar, sameType} {
+			for i, a := range o.Matchers {
+				if pairs[i] >= 0 {
+					continue
+				}
+				for j, b := range n.Matchers {
+					if !used[j] && match(a, b) {
+						pairs[i] = j
+						used[j] = true
+						break
+					}
+				}
+			}
+		}
+	}
+
+	for i, j := range pairs {
+		if j < 0 {
+			*cs = append(*cs, Change{Type: Removed, OldPath: fp.Child(i), Old: o.Matchers[i]})
+		} else {
+			diff(o.Matchers[i], fp.Child(i), n.Matchers[j], tp.Child(j), cs)
+		}
+	}
+	for j, b := range n.Matchers {
+		if !used[j] {
+			*cs = append(*cs, Change{Type: Added, NewPath: tp.Child(j), New: b})
+		}
+	}
+}
+
+// similar returns true if b is likely a modification of a, i.e. if they are
+// operations of the same type, or leaves on the same field.
+func similar(a, b Matcher) bool {
+	switch x := a.(type) {
+	case *UnaryOp:
+		y, ok := b.(*UnaryOp)
+		return ok && x.Type == y.Type && similar(x.Matcher, y.Matcher)
+	case *NAryOp:
+		y, ok := b.(*NAryOp)
+		return ok && x.Type == y.Type
+	case *Value:
+		y, ok := b.(*Value)
+		return ok && x.Type == y.Type
+	case *KV:
+		y, ok := b.(*KV)
+		return ok && x.keyString() == y.keyString()
+	case *Capture:
+		y, ok := b.(*Capture)
+		return ok && x.Field.equal(y.Field)
+	}
+	return sameType(a, b)
+}
+
+// sameType returns true if a and b are matchers of the same type.
+func sameType(a, b Matcher) bool {
+	return fmt.Sprintf("%T", a) == fmt.Sprintf("%T", b)
+}
@@ -0,0 +1,106 @@
+package matcher
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestDiff(t *testing.T) {
+	staging := NewHostname(PrefixMatch, "staging-")
+	nginx := NewValue(Program, ExactMatch, "nginx")
+	debug := NewSeverity(Equals, captainslog.Debug)
+
+	from := NewNAryOp(And,
+		staging,
+		NewNAryOp(Or, nginx, NewValue(Program, ExactMatch, "haproxy")),
+		NewUnaryOp(Not, debug))
+
+	// Reordering commutative matchers is not a change.
+	to := NewNAryOp(And,
+		NewUnaryOp(Not, debug),
+		staging,
+		NewNAryOp(Or, NewValue(Program, ExactMatch, "haproxy"), nginx))
+	if cs := Diff(from, to); len(cs) != 0 {
+		t.Errorf("want no changes, got:\n%s", cs)
+	}
+
+	to = NewNAryOp(And,
+		NewUnaryOp(Not, NewSeverity(LessThan, captainslog.Info)),
+		NewHostname(PrefixMatch, "staging-"),
+		NewNAryOp(Or, nginx, NewValue(Program, ExactMatch, "envoy")),
+		NewKV("status", GreaterThanEqual, 500))
+
+	want := "~ /1/1 -> /2/1: program(exact_match, \"haproxy\") -> program(exact_match, \"envoy\")\n" +
+		"~ /2/0 -> /0/0: severity(equals, debug) -> severity(lt, info)\n" +
+		"+ /3: kv(\"status\", gte, 500)\n"
+	if got := Diff(from, to).String(); want != got {
+		t.Errorf("want != got, want =\n%v\ngot =\n%v", want, got)
+	}
+
+	to = NewNAryOp(Or, staging, NewUnaryOp(Not, debug))
+	want = "~ /: and -> or\n" +
+		"- /1: (program(exact_match, \"nginx\") or program(exact_match, \"haproxy\"))\n"
+	if got := Diff(from, to).String(); want != got {
+		t.Errorf("want != got, want =\n%v\ngot =\n%v", want, got)
+	}
+
+	cs := Diff(staging, nginx)
+	if want, got := 1, len(cs); want != got {
+		t.Fatalf("want != got, want = %v, got = %v", want, got)
+	}
+	b, err := json.Marshal(cs.Encode())
+	if err != nil {
+		t.Fatalf("failed to marshal changes: %v", err)
+	}
+	if want, got := `[{"new":{"value_matcher":{"match_type":"exact_match","type":"program","value":"nginx"}},"new_path":"/","old":{"hostname_matcher":{"hostname":"staging-","match_type":"prefix_match"}},"old_path":"/","type":"modified"}]`, string(b); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestDiffRule(t *testing.T) {
+	from := NewRule("r", NewNAryOp(And, NewHostname(PrefixMatch, "web-"), NewValue(Program, ExactMatch, "nginx")))
+	from.Owner = "team-a"
+
+	to := NewRule("r", NewNAryOp(And, NewHostname(PrefixMatch, "web-"), NewValue(Program, ExactMatch, "envoy")))
+	to.Owner = "team-b"
+	to.Expires = time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	want := "~ /: rule(\"r\", owner=\"team-a\") -> rule(\"r\", owner=\"team-b\", expires=\"2030-01-02T00:00:00Z\")\n" +
+		"~ /0/1: program(exact_match, \"nginx\") -> program(exact_match, \"envoy\")\n"
+	if got := Diff(from, to).String(); want != got {
+		t.Errorf("want != got, want =\n%v\ngot =\n%v", want, got)
+	}
+
+	// Only the children changed.
+	to.Owner, to.Expires = from.Owner, time.Time{}
+	want = "~ /0/1: program(exact_match, \"nginx\") -> program(exact_match, \"envoy\")\n"
+	if got := Diff(from, to).String(); want != got {
+		t.Errorf("want != got, want =\n%v\ngot =\n%v", want, got)
+	}
+
+	// Only the rule changed.
+	to = NewRule("r", from.Matcher)
+	want = "~ /: rule(\"r\", owner=\"team-a\") -> rule(\"r\")\n"
+	if got := Diff(from, to).String(); want != got {
+		t.Errorf("want != got, want =\n%v\ngot =\n%v", want, got)
+	}
+}
+
+func TestPath(t *testing.T) {
+	p := Path{}
+	if want, got := "/", p.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	c := p.Child(1)
+	d := c.Child(0)
+	e := c.Child(2)
+	if want, got := "/1/0", d.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := "/1/2", e.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
@@ -0,0 +1,188 @@
+package matcher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Document is a rule file: a list of rules and the named definitions which
+// the rules, and other definitions, reference with a Ref.
+type Document struct {
+	Definitions map[string]Matcher
+	Rules       Matchers
+}
+
+// NewDocument returns a new Document with the specified definitions and
+// rules. Refs in the rules and definitions are not resolved.
+func NewDocument(d map[string]Matcher, r ...Matcher) *Document {
+	return &Document{
+		Definitions: d,
+		Rules:       r,
+	}
+}
+
+// Decode decodes a document map into a Document type, and resolves every Ref
+// to its definition. It fails if a Ref names a missing definition or if
+// definitions reference each other in a cycle.
+func (d *Document) Decode(m map[string]interface{}) error {
+	foundRules := false
+	for k, v := range m {
+		switch k {
+		case "definitions":
+			defs, ok := v.(map[string]interface{})
+			if !ok {
+				if v == nil {
+					continue
+				}
+				return fmt.Errorf("failed to decode document, definitions is not a map")
+			}
+
+			d.Definitions = make(map[string]Matcher, len(defs))
+			for name, def := range defs {
+				dm, ok := def.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("failed to decode document, definition %q is not a map", name)
+				}
+				matcher, err := Decode(dm)
+				if err != nil {
+					return fmt.Errorf("failed to decode definition %q: %v", name, err)
+				}
+				d.Definitions[name] = matcher
+			}
+		case "rules":
+			foundRules = true
+
+			if rules, ok := v.([]interface{}); ok {
+				ms, err := DecodeArray(rules)
+				if err != nil {
+					return err
+				}
+				d.Rules = ms
+			} else {
+				return fmt.Errorf("failed to decode document, rules is not a slice")
+			}
+		}
+	}
+
+	if !foundRules {
+		return fmt.Errorf("failed to decode document, missing fields")
+	}
+
+	return d.Resolve()
+}
+
+// Encode encodes a Document into a document map. Refs are encoded by name.
+func (d *Document) Encode(out map[string]interface{}) {
+	if len(d.Definitions) > 0 {
+		defs := make(map[string]interface{}, len(d.Definitions))
+		for name, def := range d.Definitions {
+			dm := make(map[string]interface{})
+			Encode(def, dm)
+			defs[name] = dm
+		}
+		out["definitions"] = defs
+	}
+
+	rules := make([]interface{}, len(d.Rules))
+	for i, r := range d.Rules {
+		rm := make(map[string]interface{})
+		Encode(r, rm)
+		rules[i] = rm
+	}
+	out["rules"] = rules
+}
+
+// Resolve points every Ref of the rules and definitions to its definition.
+// It fails if a Ref names a missing definition or if definitions reference
+// each other in a cycle, which would make matching loop forever.
+func (d *Document) Resolve() error {
+	return d.resolve(nil, nil)
+}
+
+// resolve resolves the refs of the document, prefixing the errors of a
+// definition with its position in defPos and those of the i'th rule with
+// rulePos[i], when they are known.
+func (d *Document) resolve(defPos map[string]string, rulePos []string) error {
+	const (
+		unvisited = iota
+		visiting
+		resolved
+	)
+	state := make(map[string]int, len(d.Definitions))
+
+	at := func(pos string, err error) error {
+		if pos == "" {
+			return err
+		}
+		return fmt.Errorf("%s: %v", pos, err)
+	}
+
+	var visit func(name string, chain []string) error
+	refs := func(m Matcher, pos string, chain []string) error {
+		var err error
+		Walk(m, func(node Matcher, path Path) bool {
+			r, ok := node.(*Ref)
+			if !ok || err != nil {
+				return err == nil
+			}
+			def, ok := d.Definitions[r.Name]
+			if !ok {
+				err = at(pos, fmt.Errorf("failed to decode ref matcher, undefined definition %q", r.Name))
+				return false
+			}
+			if err = visit(r.Name, chain); err == nil {
+				r.Matcher = def
+			}
+			return false
+		})
+		return err
+	}
+	visit = func(name string, chain []string) error {
+		switch state[name] {
+		case visiting:
+			return at(defPos[name], fmt.Errorf("failed to decode ref matcher, cycle in definitions %s", strings.Join(append(chain, name), " -> ")))
+		case resolved:
+			return nil
+		}
+		state[name] = visiting
+		if err := refs(d.Definitions[name], defPos[name], append(chain, name)); err != nil {
+			return err
+		}
+		state[name] = resolved
+		return nil
+	}
+
+	names := make([]string, 0, len(d.Definitions))
+	for name := range d.Definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+
+	for i, r := range d.Rules {
+		var pos string
+		if i < len(rulePos) {
+			pos = rulePos[i]
+		}
+		if err := refs(r, pos, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Expanded returns the rules of the Document with every Ref replaced by its
+// definition.
+func (d *Document) Expanded() Matchers {
+	out := make(Matchers, len(d.Rules))
+	for i, r := range d.Rules {
+		out[i] = Expand(r)
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+package matcher
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func decodeDocument(s string) (*Document, error) {
+	in := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(s), &in); err != nil {
+		return nil, err
+	}
+	d := &Document{}
+	err := d.Decode(in)
+	return d, err
+}
+
+func TestDocument(t *testing.T) {
+	d, err := decodeDocument(`{
+		"definitions": {
+			"staging_host": {"hostname_matcher": {"match_type": "prefix_match", "hostname": "staging-"}},
+			"noisy_staging": {"n_ary_op": {"type": "and", "matchers": [
+				{"ref_matcher": {"name": "staging_host"}},
+				{"value_matcher": {"type": "program", "match_type": "exact_match", "value": "cron"}}
+			]}}
+		},
+		"rules": [
+			{"ref_matcher": {"name": "noisy_staging"}},
+			{"unary_op": {"type": "not", "matcher": {"ref_matcher": {"name": "staging_host"}}}}
+		]
+	}`)
+	if err != nil {
+		t.Fatalf("failed to decode document: %v", err)
+	}
+
+	if want, got := `ref("noisy_staging")`, d.Rules[0].String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	want := []string{
+		`(hostname(prefix_match, staging-) and program(exact_match, "cron"))`,
+		`not hostname(prefix_match, staging-)`,
+	}
+	var got []string
+	for _, r := range d.Expanded() {
+		got = append(got, r.String())
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	m := captainslog.NewSyslogMsg()
+	m.Host = "staging-1"
+	m.Tag.Program = "cron"
+	if !d.Rules[0].Matches(m) {
+		t.Errorf("%s did not match", d.Rules[0])
+	}
+	if d.Rules[1].Matches(m) {
+		t.Errorf("%s matched", d.Rules[1])
+	}
+
+	// Refs are encoded by name.
+	out := make(map[string]interface{})
+	d.Encode(out)
+	b, err := json.Marshal(out)
+	if err != nil {
+		t.Fatalf("failed to marshal document: %v", err)
+	}
+	decoded, err := decodeDocument(string(b))
+	if err != nil {
+		t.Fatalf("failed to decode encoded document: %v", err)
+	}
+	for i := range d.Rules {
+		if want, got := Expand(d.Rules[i]).String(), Expand(decoded.Rules[i]).String(); want != got {
+			t.Errorf("want != got, want = %v, got = %v", want, got)
+		}
+	}
+	if want, got := len(d.Definitions), len(decoded.Definitions); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestDocumentErrors(t *testing.T) {
+	for _, tc := range []struct {
+		doc  string
+		want string
+	}{
+		{
+			`{"rules": [{"ref_matcher": {"name": "missing"}}]}`,
+			`failed to decode ref matcher, undefined definition "missing"`,
+		},
+		{
+			`{"definitions": {"a": {"ref_matcher": {"name": "a"}}}, "rules": []}`,
+			`failed to decode ref matcher, cycle in definitions a -> a`,
+		},
+		{
+			`{"definitions": {
+				"a": {"unary_op": {"type": "not", "matcher": {"ref_matcher": {"name": "b"}}}},
+				"b": {"n_ary_op": {"type": "or", "matchers": [{"constant_matcher": {"value": true}}, {"ref_matcher": {"name": "a"}}]}}
+			}, "rules": []}`,
+			`failed to decode ref matcher, cycle in definitions a -> b -> a`,
+		},
+		{
+			`{"definitions": {}}`,
+			`failed to decode document, missing fields`,
+		},
+	} {
+		_, err := decodeDocument(tc.doc)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("want != got, want = %v, got = %v", tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package matcher
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Equal returns true if the two matcher trees are equivalent up to the order
+// of the matchers of commutative operations, i.e. if their canonical forms
+// are identical. Matchers of types not defined in this package are compared
+// by type and string representation.
+func Equal(a, b Matcher) bool {
+	return canonicalKey(a) == canonicalKey(b)
+}
+
+// Hash returns a stable hash of the matcher tree. Matchers which are Equal
+// have the same hash.
+func Hash(m Matcher) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(canonicalKey(m)))
+	return h.Sum64()
+}
+
+// Canonical returns the canonical form of the matcher tree, in which the
+// matchers of commutative operations (all n-ary operations except implies)
+// are sorted. The supplied tree is not modified, although leaf matchers are
+// shared between the two trees.
+func Canonical(m Matcher) Matcher {
+	return Transform(m, func(n Matcher) Matcher {
+		if o, ok := n.(*AdaptiveOp); ok {
+			// The canonical form does not depend on the learned order.
+			n = o.frozen()
+		}
+		if o, ok := n.(*NAryOp); ok && o.Type != Implies {
+			sortMatchers(o.Matchers)
+		}
+		return n
+	})
+}
+
+// sortMatchers sorts matchers by their canonical keys.
+func sortMatchers(cs Matchers) {
+	keys := make([]string, len(cs))
+	for i, c := range cs {
+		keys[i] = canonicalKey(c)
+	}
+	sort.Sort(byKey{cs, keys})
+}
+
+// byKey sorts matchers by precomputed keys.
+type byKey struct {
+	cs   Matchers
+	keys []string
+}
+
+func (s byKey) Len() int           { return len(s.cs) }
+func (s byKey) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s byKey) Swap(i, j int) {
+	s.cs[i], s.cs[j] = s.cs[j], s.cs[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+// canonicalKey returns a string which uniquely identifies the canonical form
+// of the matcher tree.
+func canonicalKey(m Matcher) string {
+	var b bytes.Buffer
+	writeKey(&b, m)
+	return b.String()
+}
+
+// writeKey writes the canonical key of the matcher tree to b.
+func writeKey(b *bytes.Buffer, m Matcher) {
+	if m == nil {
+		// E.g. the matcher of an unresolved Ref, or a Rule without one.
+		b.WriteString("nil")
+		return
+	}
+
+	switch o := m.(type) {
+	case *Constant:
+		fmt.Fprintf(b, "constant(%t)", o.Value)
+	case *Hostname:
+		fmt.Fprintf(b, "hostname(%d,%q)", o.MatchType, o.NameMatcher)
+	case *Value:
+		fmt.Fprintf(b, "value(%d,%d,%q)", o.Type, o.MatchType, o.Value)
+	case *Facility:
+		fmt.Fprintf(b, "facility(%d)", o.Facility)
+	case *Severity:
+		fmt.Fprintf(b, "severity(%d,%d)", o.MatchType, o.Severity)
+	case *Timestamp:
+		fmt.Fprintf(b, "timestamp(%d,%s,%q)", o.MatchType, timeKey(o.Timestamp.Time), o.Timestamp.TimeFormat)
+	case *KV:
+		fmt.Fprintf(b, "kv(%q,%d,%s,%d,%d)", o.keyString(), o.MatchType, valueKey(o.Value), o.Coercion, o.Format)
+	case *FieldCompare:
+		fmt.Fprintf(b, "compare(%q,%d,%q,%d)", o.Left, o.MatchType, o.Right, o.Coercion)
+	case *Capture:
+		fmt.Fprintf(b, "capture(%q,%q,%q,%d,%s)", o.Field, o.Pattern, o.Group, o.MatchType, valueKey(o.Value))
+	case *List:
+		fmt.Fprintf(b, "list(%q,%q)", o.Field, o.Name)
+	case *Ref:
+		fmt.Fprintf(b, "ref(%q,", o.Name)
+		writeKey(b, o.Matcher)
+		b.WriteByte(')')
+	case *Rule:
+		labels := make([]string, 0, len(o.Labels))
+		for k, v := range o.Labels {
+			labels = append(labels, fmt.Sprintf("%q=%q", k, v))
+		}
+		sort.Strings(labels)
+		fmt.Fprintf(b, "rule(%q,%q,%q,%q,{%s},%s,%s,", o.ID, o.Name, o.Description, o.Owner,
+			strings.Join(labels, ","), timeKey(o.Created), timeKey(o.Expires))
+		writeKey(b, o.Matcher)
+		b.WriteByte(')')
+	case *UnaryOp:
+		fmt.Fprintf(b, "unary(%d,", o.Type)
+		writeKey(b, o.Matcher)
+		b.WriteByte(')')
+	case *NAryOp:
+		keys := make([]string, len(o.Matchers))
+		for i, c := range o.Matchers {
+			keys[i] = canonicalKey(c)
+		}
+		if o.Type != Implies {
+			sort.Strings(keys)
+		}
+		count := 0
+		if o.Type.IsThreshold() {
+			count = o.Count
+		}
+		fmt.Fprintf(b, "nary(%d,%d", o.Type, count)
+		for _, k := range keys {
+			b.WriteByte(',')
+			b.WriteString(k)
+		}
+		b.WriteByte(')')
+	case *AdaptiveOp:
+		// The learned order is irrelevant, as the matchers are sorted.
+		writeKey(b, o.NAryOp)
+	default:
+		fmt.Fprintf(b, "%T(%q)", m, m.String())
+	}
+}
+
+// timeKey returns a string which uniquely identifies an instant, regardless of
+// its location.
+func timeKey(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+// valueKey returns a string which uniquely identifies a KV or Capture value.
+func valueKey(v interface{}) string {
+	switch x := v.(type) {
+	case time.Duration:
+		return fmt.Sprintf("d:%d", int64(x))
+	case Var:
+		return fmt.Sprintf("v:%s:%q", x.Type, x.Name)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("s:%q", rv.String())
+	case reflect.Float64:
+		return fmt.Sprintf("f:%x", math.Float64bits(rv.Float()))
+	case reflect.Bool:
+		return fmt.Sprintf("b:%t", rv.Bool())
+	}
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+// equal returns true if the two matcher trees are structurally identical,
+// with children compared in order. Unlike Equal, it does not build canonical
+// keys, so it is cheap enough for the pairwise comparisons of Simplify.
+func equal(a, b Matcher) bool {
+	switch x := a.(type) {
+	case *Constant:
+		y, ok := b.(*Constant)
+		return ok && *x == *y
+	case *Hostname:
+		y, ok := b.(*Hostname)
+		return ok && *x == *y
+	case *Value:
+		y, ok := b.(*Value)
+		return ok && *x == *y
+	case *Facility:
+		y, ok := b.(*Facility)
+		return ok && *x == *y
+	case *Severity:
+		y, ok := b.(*Severity)
+		return ok && *x == *y
+	case *Timestamp:
+		y, ok := b.(*Timestamp)
+		return ok && x.MatchType == y.MatchType &&
+			x.Timestamp.Time.Equal(y.Timestamp.Time) &&
+			x.Timestamp.TimeFormat == y.Timestamp.TimeFormat
+	case *KV:
+		y, ok := b.(*KV)
+		return ok && x.keyString() == y.keyString() &&
+			x.MatchType == y.MatchType &&
+			reflect.DeepEqual(x.Value, y.Value) &&
+			x.Coercion == y.Coercion &&
+			x.Format == y.Format
+	case *FieldCompare:
+		y, ok := b.(*FieldCompare)
+		return ok && x.Left.equal(y.Left) &&
+			x.MatchType == y.MatchType &&
+			x.Right.equal(y.Right) &&
+			x.Coercion == y.Coercion
+	case *Capture:
+		y, ok := b.(*Capture)
+		return ok && x.Field.equal(y.Field) &&
+			x.Pattern == y.Pattern &&
+			x.Group == y.Group &&
+			x.MatchType == y.MatchType &&
+			reflect.DeepEqual(x.Value, y.Value)
+	case *UnaryOp:
+		y, ok := b.(*UnaryOp)
+		return ok && x.Type == y.Type && equal(x.Matcher, y.Matcher)
+	case *NAryOp:
+		y, ok := b.(*NAryOp)
+		if !ok || x.Type != y.Type || len(x.Matchers) != len(y.Matchers) {
+			return false
+		}
+		if x.Type.IsThreshold() && x.Count != y.Count {
+			return false
+		}
+		for i := range x.Matchers {
+			if !equal(x.Matchers[i], y.Matchers[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// equal returns true if the two fields reference the same value.
+func (f Field) equal(g Field) bool {
+	if f.Type != g.Type {
+		return false
+	}
+	if f.Type != KeyField {
+		return true
+	}
+	return f.String() == g.String()
+}
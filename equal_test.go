@@ -0,0 +1,172 @@
+package matcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestEqual(t *testing.T) {
+	a := NewHostname(PrefixMatch, "staging-")
+	b := NewValue(Program, ExactMatch, "nginx")
+	c := NewKV("status", GreaterThanEqual, 500)
+	d := NewSeverity(LessThan, captainslog.Warning)
+
+	x := NewNAryOp(And, a, NewNAryOp(Or, b, c), NewUnaryOp(Not, d))
+	y := NewNAryOp(And, NewUnaryOp(Not, d), NewNAryOp(Or, c, b), a)
+
+	if want, got := true, Equal(x, y); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := Hash(x), Hash(y); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	before := y.String()
+	if want, got := Canonical(x).String(), Canonical(y).String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := before, y.String(); want != got {
+		t.Errorf("Canonical modified its input, want = %v, got = %v", want, got)
+	}
+
+	for _, z := range []Matcher{
+		NewNAryOp(Or, a, NewNAryOp(Or, b, c), NewUnaryOp(Not, d)),
+		NewNAryOp(And, a, NewNAryOp(Or, b, c), d),
+		NewNAryOp(And, a, NewNAryOp(Or, b, NewKV("status", GreaterThanEqual, "500")), NewUnaryOp(Not, d)),
+		NewNAryOp(And, a, NewNAryOp(Or, b, c)),
+		NewNAryOp(And, NewHostname(PrefixMatch, "staging"), NewNAryOp(Or, b, c), NewUnaryOp(Not, d)),
+	} {
+		if want, got := false, Equal(x, z); want != got {
+			t.Errorf("Equal(%s, %s): want != got, want = %v, got = %v", x, z, want, got)
+		}
+		if Hash(x) == Hash(z) {
+			t.Errorf("Hash(%s) == Hash(%s)", x, z)
+		}
+	}
+
+	// Implies is not commutative.
+	if want, got := false, Equal(NewNAryOp(Implies, a, b), NewNAryOp(Implies, b, a)); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := false, Equal(NewThresholdOp(AtLeast, 1, a, b), NewThresholdOp(AtLeast, 2, b, a)); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := true, Equal(NewThresholdOp(AtLeast, 1, a, b), NewThresholdOp(AtLeast, 1, b, a)); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	// Equivalent keys are equal.
+	if want, got := true, Equal(NewKV(`a\.b`, Equals, true), NewKV(`["a.b"]`, Equals, true)); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	now := time.Now()
+	if want, got := true, Equal(
+		NewTimestamp(LessThan, captainslog.Time{Time: now, TimeFormat: time.Stamp}),
+		NewTimestamp(LessThan, captainslog.Time{Time: now.UTC(), TimeFormat: time.Stamp})); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestEqualParents(t *testing.T) {
+	a := NewHostname(PrefixMatch, "staging-")
+	b := NewValue(Program, ExactMatch, "nginx")
+
+	rule := func(edit func(r *Rule)) *Rule {
+		r := NewRule("r", NewNAryOp(And, a, b))
+		r.Owner = "team-a"
+		r.Labels = map[string]string{"env": "prod", "tier": "web"}
+		r.Expires = time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)
+		if edit != nil {
+			edit(r)
+		}
+		return r
+	}
+
+	for _, c := range []struct {
+		x, y Matcher
+		want bool
+	}{
+		{rule(nil), rule(func(r *Rule) { r.Matcher = NewNAryOp(And, b, a) }), true},
+		{rule(nil), rule(func(r *Rule) { r.Expires = r.Expires.In(time.FixedZone("x", 3600)) }), true},
+		{rule(nil), rule(func(r *Rule) { r.Owner = "team-b" }), false},
+		{rule(nil), rule(func(r *Rule) { r.Expires = time.Time{} }), false},
+		{rule(nil), rule(func(r *Rule) { r.Labels = map[string]string{"env": "prod"} }), false},
+		{rule(nil), rule(func(r *Rule) { r.Description = "d" }), false},
+		{rule(nil), rule(func(r *Rule) { r.Matcher = a }), false},
+		{NewRef("d", a), NewRef("d", a), true},
+		{NewRef("d", a), NewRef("e", a), false},
+		{NewRef("d", a), NewRef("d", b), false},
+		{NewList(NewField(HostField, ""), "hosts"), NewList(NewField(HostField, ""), "hosts"), true},
+		{NewList(NewField(HostField, ""), "hosts"), NewList(NewField(HostField, ""), "dbs"), false},
+		{NewList(NewField(HostField, ""), "hosts"), NewList(NewField(ProgramField, ""), "hosts"), false},
+		{NewAdaptiveOp(And, 1, a, b), NewNAryOp(And, b, a), true},
+		{NewAdaptiveOp(And, 1, a, b), NewAdaptiveOp(Or, 1, a, b), false},
+	} {
+		if want, got := c.want, Equal(c.x, c.y); want != got {
+			t.Errorf("Equal(%s, %s): want != got, want = %v, got = %v", c.x, c.y, want, got)
+		}
+		if want, got := c.want, Hash(c.x) == Hash(c.y); want != got {
+			t.Errorf("Hash(%s) == Hash(%s): want != got, want = %v, got = %v", c.x, c.y, want, got)
+		}
+	}
+
+	// The learned order of an AdaptiveOp does not change its hash.
+	never := NewHostname(ExactMatch, "never")
+	o := NewAdaptiveOp(And, 1, NewConstant(true), never)
+	before := Hash(o)
+	for i := 0; i < 16; i++ {
+		o.Matches(captainslog.NewSyslogMsg())
+	}
+	if want, got := Matcher(never), o.Children()[0]; want != got {
+		t.Fatalf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := before, Hash(o); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestEqualNilMatcher(t *testing.T) {
+	a := NewHostname(PrefixMatch, "staging-")
+
+	for _, c := range []struct {
+		x, y Matcher
+		want bool
+	}{
+		{NewRule("r", nil), NewRule("r", nil), true},
+		{NewRule("r", nil), NewRule("r", a), false},
+		{NewUnaryOp(Not, nil), NewUnaryOp(Not, nil), true},
+		{NewNAryOp(And, NewUnaryOp(Not, nil), a), NewNAryOp(And, a, NewUnaryOp(Not, nil)), true},
+		{NewRef("d", nil), NewRef("d", a), false},
+	} {
+		if want, got := c.want, Equal(c.x, c.y); want != got {
+			t.Errorf("Equal(%s, %s): want != got, want = %v, got = %v", c.x, c.y, want, got)
+		}
+		if want, got := c.want, Hash(c.x) == Hash(c.y); want != got {
+			t.Errorf("Hash(%s) == Hash(%s): want != got, want = %v, got = %v", c.x, c.y, want, got)
+		}
+		if want, got := c.want, Equal(Canonical(c.x), Canonical(c.y)); want != got {
+			t.Errorf("Equal(Canonical(%s), Canonical(%s)): want != got, want = %v, got = %v", c.x, c.y, want, got)
+		}
+	}
+
+	want := "+ /0: hostname(prefix_match, staging-)\n"
+	if got := Diff(NewRule("r", nil), NewRule("r", a)).String(); want != got {
+		t.Errorf("want != got, want =\n%v\ngot =\n%v", want, got)
+	}
+	want = "- /0: hostname(prefix_match, staging-)\n"
+	if got := Diff(NewUnaryOp(Not, a), NewUnaryOp(Not, nil)).String(); want != got {
+		t.Errorf("want != got, want =\n%v\ngot =\n%v", want, got)
+	}
+}
+
+func TestHashStable(t *testing.T) {
+	m := NewNAryOp(Or, NewValue(Program, ExactMatch, "b"), NewHostname(ExactMatch, "a"))
+
+	// The hash is persisted by callers, so it must not change between
+	// releases.
+	if want, got := uint64(0xb3569d3688c42dec), Hash(m); want != got {
+		t.Errorf("want != got, want = %#x, got = %#x", want, got)
+	}
+}
@@ -0,0 +1,163 @@
+package matcher
+
+import (
+	"fmt"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// FieldType is the enum class for representing the fields of a syslog message
+// that may be referenced by a Field.
+type FieldType int
+
+// Field types.
+const (
+	HostField FieldType = iota
+	ProgramField
+	ContentField
+	KeyField
+)
+
+// String converts a FieldType to its corresponding string representation.
+func (t FieldType) String() string {
+	switch t {
+	case HostField:
+		return "host"
+	case ProgramField:
+		return "program"
+	case ContentField:
+		return "content"
+	case KeyField:
+		return "kv"
+	default:
+		return "invalid type"
+	}
+}
+
+// FromString converts the FieldType to the value corresponding to the supplied
+// string representation.
+func (t *FieldType) FromString(s string) error {
+	switch s {
+	case "host":
+		*t = HostField
+	case "program":
+		*t = ProgramField
+	case "content":
+		*t = ContentField
+	case "kv":
+		*t = KeyField
+	default:
+		return fmt.Errorf("failed to convert string to FieldType")
+	}
+
+	return nil
+}
+
+// Field references a single value of a syslog message, either a header field
+// or a key in its JSON content.
+type Field struct {
+	Type FieldType
+	// Key is the key path of a KeyField, see ParseKeyPath.
+	Key string
+
+	// path is the parsed Key, set by NewField and Decode.
+	path KeyPath
+}
+
+// NewField returns a new Field of the specified type. The key is only used by
+// a KeyField.
+func NewField(t FieldType, k string) Field {
+	f := Field{
+		Type: t,
+		Key:  k,
+	}
+	if t == KeyField {
+		f.path = parseKey(k)
+	}
+	return f
+}
+
+// String converts a Field to its corresponding string representation.
+func (f Field) String() string {
+	if f.Type == KeyField {
+		if p, err := ParseKeyPath(f.Key); err == nil {
+			return fmt.Sprintf("kv(%q)", p.String())
+		}
+		return fmt.Sprintf("kv(%q)", f.Key)
+	}
+	return f.Type.String()
+}
+
+// value returns the value of the Field in the supplied SyslogMsg. It returns
+// false if the field is not present.
+func (f Field) value(m captainslog.SyslogMsg) (interface{}, bool) {
+	switch f.Type {
+	case HostField:
+		return m.Host, true
+	case ProgramField:
+		return m.Tag.Program, true
+	case ContentField:
+		return m.Content, true
+	case KeyField:
+		if !m.IsJSON {
+			return nil, false
+		}
+		p := f.path
+		if p == nil {
+			p = parseKey(f.Key)
+		}
+		return lookupKeyPath(m.JSONValues, p)
+	}
+
+	return nil, false
+}
+
+// Decode decodes a field map into a Field.
+func (f *Field) Decode(m map[string]interface{}) error {
+	foundType := false
+	foundKey := false
+	for k, v := range m {
+		switch k {
+		case "type":
+			foundType = true
+
+			if t, ok := v.(string); ok {
+				if err := f.Type.FromString(t); err != nil {
+					return err
+				}
+			} else {
+				return fmt.Errorf("failed to decode field, type is not a string")
+			}
+		case "key":
+			foundKey = true
+
+			if key, ok := v.(string); ok {
+				f.Key = key
+			} else {
+				return fmt.Errorf("failed to decode field, key is not a string")
+			}
+		}
+	}
+
+	if !foundType || (f.Type == KeyField && !foundKey) {
+		return fmt.Errorf("failed to decode field, missing fields")
+	}
+
+	if f.Type == KeyField {
+		f.path = parseKey(f.Key)
+	}
+
+	return nil
+}
+
+// Encode encodes a Field into a field map.
+func (f Field) Encode(out map[string]interface{}) {
+	out["type"] = f.Type.String()
+	if f.Type == KeyField {
+		if p, err := ParseKeyPath(f.Key); err == nil {
+			out["key"] = p.String()
+		} else {
+			out["key"] = f.Key
+		}
+	}
+}
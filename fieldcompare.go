@@ -0,0 +1,145 @@
+package matcher
+
+import (
+	"fmt"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// FieldCompare represents a matcher comparing two fields of the same syslog
+// message, e.g. two JSON keys, or the hostname and a JSON key.
+type FieldCompare struct {
+	Left      Field
+	MatchType MatchType
+	Right     Field
+
+	// Coercion is the set of conversions applied to either field when its
+	// type is not the type required by the MatchType.
+	Coercion Coercion
+}
+
+// NewFieldCompare returns a new FieldCompare comparing the left field to the
+// right field with the specified match type.
+func NewFieldCompare(l Field, m MatchType, r Field) *FieldCompare {
+	return &FieldCompare{
+		Left:      l,
+		MatchType: m,
+		Right:     r,
+	}
+}
+
+// String converts a FieldCompare to its corresponding string representation.
+func (c FieldCompare) String() string {
+	if c.Coercion != 0 {
+		return fmt.Sprintf("compare(%s, %s, %s, coerce=\"%s\")", c.Left, c.MatchType, c.Right, c.Coercion)
+	}
+	return fmt.Sprintf("compare(%s, %s, %s)", c.Left, c.MatchType, c.Right)
+}
+
+// Matches returns true if the left field matches the right field of the
+// supplied SyslogMsg. String match types compare both fields as strings, with
+// the right field as the pattern, and numeric match types compare both fields
+// as numbers. Equals compares both fields as numbers, bools or strings, in
+// that order, using the first type to which both fields convert.
+func (c *FieldCompare) Matches(m captainslog.SyslogMsg) bool {
+	l, ok := c.Left.value(m)
+	if !ok {
+		return false
+	}
+	r, ok := c.Right.value(m)
+	if !ok {
+		return false
+	}
+
+	switch c.MatchType {
+	case ExactMatch, PrefixMatch, Contains, Regex:
+		lv, lok := asString(l, c.Coercion)
+		rv, rok := asString(r, c.Coercion)
+		return lok && rok && compareString(c.MatchType, lv, rv)
+	case LessThan, LessThanEqual, GreaterThan, GreaterThanEqual:
+		lv, lok := asFloat(l, c.Coercion)
+		rv, rok := asFloat(r, c.Coercion)
+		return lok && rok && compareFloat(c.MatchType, lv, rv)
+	case Equals:
+		if lv, lok := asFloat(l, c.Coercion); lok {
+			if rv, rok := asFloat(r, c.Coercion); rok {
+				return lv == rv
+			}
+		}
+		if lv, lok := asBool(l, c.Coercion); lok {
+			if rv, rok := asBool(r, c.Coercion); rok {
+				return lv == rv
+			}
+		}
+		lv, lok := asString(l, c.Coercion)
+		rv, rok := asString(r, c.Coercion)
+		return lok && rok && lv == rv
+	}
+
+	return false
+}
+
+// Decode decodes a matcher map into a FieldCompare type.
+func (c *FieldCompare) Decode(m map[string]interface{}) error {
+	foundLeft := false
+	foundMatchType := false
+	foundRight := false
+	for k, v := range m {
+		switch k {
+		case "left", "right":
+			f, ok := v.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("failed to decode field compare matcher, %s is not a map", k)
+			}
+			if k == "left" {
+				foundLeft = true
+				if err := c.Left.Decode(f); err != nil {
+					return err
+				}
+			} else {
+				foundRight = true
+				if err := c.Right.Decode(f); err != nil {
+					return err
+				}
+			}
+		case "match_type":
+			foundMatchType = true
+
+			if mt, ok := v.(string); ok {
+				if err := c.MatchType.FromString(mt); err != nil {
+					return err
+				}
+			} else {
+				return fmt.Errorf("failed to decode field compare matcher, match_type is not a string")
+			}
+		case "coerce":
+			if v != nil {
+				if err := c.Coercion.Decode(v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if !(foundLeft && foundMatchType && foundRight) {
+		return fmt.Errorf("failed to decode field compare matcher, missing fields")
+	}
+
+	return nil
+}
+
+// Encode encodes a FieldCompare into a matcher map.
+func (c *FieldCompare) Encode(out map[string]interface{}) {
+	left := make(map[string]interface{})
+	c.Left.Encode(left)
+	right := make(map[string]interface{})
+	c.Right.Encode(right)
+
+	out["left"] = left
+	out["match_type"] = c.MatchType.String()
+	out["right"] = right
+
+	if c.Coercion != 0 {
+		out["coerce"] = c.Coercion.Encode()
+	}
+}
@@ -2,7 +2,10 @@ module github.com/digitalocean/logmatcher
 
 go 1.19
 
-require github.com/digitalocean/captainslog v0.1.14
+require (
+	github.com/digitalocean/captainslog v0.1.14
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/tidwall/gjson v1.14.4 // indirect
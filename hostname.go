@@ -62,7 +62,8 @@ func (h *Hostname) Decode(m map[string]interface{}) error {
 		case "hostname":
 			hostIsString = true
 
-			if _, ok := v.(string); ok {
+			if n, ok := v.(string); ok {
+				h.NameMatcher = n
 			} else {
 				return fmt.Errorf("failed to decode hostname matcher, hostname is not a string")
 			}
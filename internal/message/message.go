@@ -0,0 +1,120 @@
+// Package message parses syslog messages for cmd/logmatch and matchertest.
+package message
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// Parse parses an RFC 5424 or RFC 3164 syslog message, such as a line
+// of a log file.
+func Parse(line []byte) (captainslog.SyslogMsg, error) {
+	if msg, ok, err := parse5424(line); ok {
+		return msg, err
+	}
+	return captainslog.NewSyslogMsgFromBytes(line)
+}
+
+// errBad5424 is returned for a message which starts like an RFC 5424 message
+// but is malformed.
+var errBad5424 = errors.New("malformed RFC 5424 message")
+
+// utf8BOM may start the MSG part of an RFC 5424 message.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// parse5424 parses an RFC 5424 message. It returns false if the line is not
+// an RFC 5424 message, i.e. if the priority is not followed by version 1. The
+// APP-NAME is the program and the PROCID the pid; structured data is skipped.
+func parse5424(line []byte) (captainslog.SyslogMsg, bool, error) {
+	msg := captainslog.NewSyslogMsg()
+
+	offset, pri, err := captainslog.ParsePri(line)
+	if err != nil || !bytes.HasPrefix(line[offset:], []byte("1 ")) {
+		return msg, false, nil
+	}
+	msg.Pri = pri
+	rest := line[offset+2:]
+
+	var fields [5][]byte
+	for i := range fields {
+		j := bytes.IndexByte(rest, ' ')
+		if j <= 0 {
+			return msg, true, errBad5424
+		}
+		fields[i], rest = rest[:j], rest[j+1:]
+	}
+	timestamp, host, app, procID := fields[0], fields[1], fields[2], fields[3]
+
+	if !nilValue(timestamp) {
+		t, err := time.Parse(time.RFC3339Nano, string(timestamp))
+		if err != nil {
+			return msg, true, errBad5424
+		}
+		msg.Time = t
+	}
+	if !nilValue(host) {
+		msg.Host = string(host)
+	}
+	if !nilValue(app) {
+		msg.Tag.Program = string(app)
+	}
+	if !nilValue(procID) {
+		msg.Tag.Pid = string(procID)
+	}
+	msg.Tag.HasColon = true
+
+	rest, ok := skipStructuredData(rest)
+	if !ok {
+		return msg, true, errBad5424
+	}
+	rest = bytes.TrimPrefix(bytes.TrimPrefix(rest, []byte(" ")), utf8BOM)
+	if len(rest) == 0 {
+		return msg, true, nil
+	}
+
+	// Content which is not valid JSON is plain text.
+	_, content, _ := captainslog.ParseContent(append([]byte(" "), rest...), captainslog.ContentOptionParseJSON)
+	msg.Content = string(rest)
+	msg.JSONValues = content.JSONValues
+	msg.IsJSON = len(content.JSONValues) > 0
+	return msg, true, nil
+}
+
+// nilValue returns true for the RFC 5424 NILVALUE.
+func nilValue(b []byte) bool {
+	return len(b) == 1 && b[0] == '-'
+}
+
+// skipStructuredData skips the STRUCTURED-DATA part, which is either the
+// NILVALUE or a sequence of [...] elements whose quoted parameter values may
+// contain escaped characters.
+func skipStructuredData(b []byte) ([]byte, bool) {
+	if len(b) > 0 && b[0] == '-' {
+		return b[1:], true
+	}
+	if len(b) == 0 || b[0] != '[' {
+		return nil, false
+	}
+
+	inElement, inQuote := false, false
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case inQuote && c == '\\':
+			i++
+		case c == '"' && inElement:
+			inQuote = !inQuote
+		case inQuote:
+		case c == '[' && !inElement:
+			inElement = true
+		case c == ']' && inElement:
+			inElement = false
+			if i+1 == len(b) || b[i+1] != '[' {
+				return b[i+1:], true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,41 @@
+package message
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	msg, ok, err := parse5424([]byte(`<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - {"user": "root"}`))
+	if !ok || err != nil {
+		t.Fatalf("failed to parse message: %v, %v", ok, err)
+	}
+	if want, got := "192.0.2.1", msg.Host; want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := "myproc", msg.Tag.Program; want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if !msg.IsJSON || msg.JSONValues["user"] != "root" {
+		t.Errorf("want JSON content, got %v", msg.JSONValues)
+	}
+
+	if _, ok, _ := parse5424([]byte(`<30>Jan  2 15:04:05 web-1 cron[12]: job ran`)); ok {
+		t.Errorf("RFC 3164 message parsed as RFC 5424")
+	}
+	msg, err = Parse([]byte(`<30>Jan  2 15:04:05 web-1 cron[12]: job ran`))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	if want, got := "cron", msg.Tag.Program; want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	for _, line := range []string{
+		`<34>1 2003-10-11T22:14:15.003Z host`,
+		`<34>1 yesterday host app - - - msg`,
+		`<34>1 2003-10-11T22:14:15.003Z host app - - [unterminated msg`,
+	} {
+		if _, ok, err := parse5424([]byte(line)); !ok || err == nil {
+			t.Errorf("%q should fail to parse", line)
+		}
+	}
+}
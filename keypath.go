@@ -0,0 +1,146 @@
+package matcher
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KeyPath is a parsed key used to dereference multi-level JSON data. Each
+// element is a single, literal map key.
+type KeyPath []string
+
+// ParseKeyPath parses a key path expression into its segments.
+//
+// Segments are separated by periods. A segment containing periods (or any
+// other special character) may either escape them with a backslash, e.g.
+// `labels.app\.kubernetes\.io/name`, or be written as a double-quoted string in
+// brackets, e.g. `labels["app.kubernetes.io/name"]`.
+func ParseKeyPath(s string) (KeyPath, error) {
+	if s == "" {
+		return nil, fmt.Errorf("failed to parse key path, key is empty")
+	}
+
+	var (
+		p      KeyPath
+		b      strings.Builder
+		quoted bool
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			if quoted {
+				return nil, fmt.Errorf("failed to parse key path %q, expected '.' or '[' at offset %d", s, i)
+			}
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("failed to parse key path %q, trailing backslash", s)
+			}
+			i++
+			b.WriteByte(s[i])
+		case '.':
+			if !quoted && b.Len() == 0 {
+				return nil, fmt.Errorf("failed to parse key path %q, empty segment at offset %d", s, i)
+			}
+			if !quoted {
+				p = append(p, b.String())
+				b.Reset()
+			}
+			quoted = false
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("failed to parse key path %q, trailing '.'", s)
+			}
+		case '[':
+			if quoted {
+				quoted = false
+			} else if b.Len() != 0 {
+				p = append(p, b.String())
+				b.Reset()
+			} else if i != 0 && s[i-1] != '.' {
+				return nil, fmt.Errorf("failed to parse key path %q, empty segment at offset %d", s, i)
+			}
+
+			end, err := quotedEnd(s, i+1)
+			if err != nil {
+				return nil, err
+			}
+			seg, err := strconv.Unquote(s[i+1 : end])
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse key path %q, invalid quoted segment at offset %d", s, i)
+			}
+			if end == len(s) || s[end] != ']' {
+				return nil, fmt.Errorf("failed to parse key path %q, unterminated '[' at offset %d", s, i)
+			}
+			p = append(p, seg)
+			i = end
+			quoted = true
+		case ']', '"':
+			return nil, fmt.Errorf("failed to parse key path %q, unexpected %q at offset %d", s, c, i)
+		default:
+			if quoted {
+				return nil, fmt.Errorf("failed to parse key path %q, expected '.' or '[' at offset %d", s, i)
+			}
+			b.WriteByte(c)
+		}
+	}
+
+	if !quoted {
+		p = append(p, b.String())
+	}
+
+	return p, nil
+}
+
+// parseKey parses a key as a KeyPath. A key which is not valid key path
+// syntax, e.g. `items[0]`, is split at its periods as keys were before escaping
+// and quoting were supported, so existing rules keep matching the same data.
+func parseKey(s string) KeyPath {
+	if p, err := ParseKeyPath(s); err == nil {
+		return p
+	}
+	return KeyPath(strings.Split(s, "."))
+}
+
+// quotedEnd returns the offset just past the double-quoted string starting at
+// offset i of s.
+func quotedEnd(s string, i int) (int, error) {
+	if i >= len(s) || s[i] != '"' {
+		return 0, fmt.Errorf("failed to parse key path %q, expected '\"' at offset %d", s, i)
+	}
+
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1, nil
+		}
+	}
+
+	return 0, fmt.Errorf("failed to parse key path %q, unterminated string at offset %d", s, i)
+}
+
+// String converts a KeyPath to its canonical string representation. Segments
+// that cannot be written plainly are written in the bracket-quoted form.
+func (p KeyPath) String() string {
+	var b bytes.Buffer
+	for i, seg := range p {
+		if plainSegment(seg) {
+			if i != 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(seg)
+		} else {
+			b.WriteByte('[')
+			b.WriteString(strconv.Quote(seg))
+			b.WriteByte(']')
+		}
+	}
+	return b.String()
+}
+
+// plainSegment returns true if the segment can be written without quoting.
+func plainSegment(s string) bool {
+	return s != "" && !strings.ContainsAny(s, `.[]"\`)
+}
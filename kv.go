@@ -1,7 +1,6 @@
 package matcher
 
 import (
-	"encoding/json"
 	"fmt"
 	"reflect"
 	"regexp"
@@ -15,10 +14,24 @@ type KV struct {
 	Key       string
 	MatchType MatchType
 	Value     interface{}
+
+	// Coercion is the set of conversions applied to the JSON value when its
+	// type differs from the type of Value. The zero value disables coercion.
+	Coercion Coercion
+
+	// Format selects where key-value pairs are read from. Values read from
+	// logfmt content are untyped, so StringToNumber and StringToBool are
+	// always applied to them.
+	Format ContentFormat
+
+	// path is the parsed Key, set by NewKV and Decode.
+	path KeyPath
 }
 
 // NewKV returns a new KV with the specified key, match type, and
-// string value.
+// string value. The key is parsed as a KeyPath; see ParseKeyPath for the
+// accepted syntax. A key which is not valid key path syntax is split at its
+// periods.
 func NewKV(k string, m MatchType, v interface{}) *KV {
 	var vNew interface{}
 
@@ -32,107 +45,202 @@ func NewKV(k string, m MatchType, v interface{}) *KV {
 		Key:       k,
 		MatchType: m,
 		Value:     vNew,
+		path:      parseKey(k),
+	}
+}
+
+// keyPath returns the parsed key, parsing it on every call if the KV was not
+// created with NewKV or Decode.
+func (kv *KV) keyPath() KeyPath {
+	if kv.path != nil {
+		return kv.path
 	}
+	return parseKey(kv.Key)
+}
+
+// keyString returns the canonical representation of the key, or the key as
+// supplied if it is not valid key path syntax.
+func (kv KV) keyString() string {
+	if p, err := ParseKeyPath(kv.Key); err == nil {
+		return p.String()
+	}
+	return kv.Key
 }
 
 // String converts a KV to its corresponding string representation.
 func (kv KV) String() string {
+	var coerce string
+	if kv.Coercion != 0 {
+		coerce = fmt.Sprintf(", coerce=\"%s\"", kv.Coercion)
+	}
+	if kv.Format != DefaultContent {
+		coerce += fmt.Sprintf(", format=\"%s\"", kv.Format)
+	}
 	if reflect.ValueOf(kv.Value).Kind() == reflect.String {
-		return fmt.Sprintf("kv(\"%s\", %s, \"%s\")", kv.Key, kv.MatchType, kv.Value)
+		return fmt.Sprintf("kv(%q, %s, \"%s\"%s)", kv.keyString(), kv.MatchType, kv.Value, coerce)
 	}
-	return fmt.Sprintf("kv(\"%s\", %s, %v)", kv.Key, kv.MatchType, kv.Value)
+	return fmt.Sprintf("kv(%q, %s, %v%s)", kv.keyString(), kv.MatchType, kv.Value, coerce)
 }
 
 // Matches returns true if the KV matches the supplied SyslogMsg.
 func (kv *KV) Matches(m captainslog.SyslogMsg) bool {
-	if !m.IsJSON {
+	matched, _ := kv.matchesContent(m, nil)
+	return matched
+}
+
+// matchesContent returns true if the KV matches the supplied SyslogMsg, whose
+// logfmt content is parsed by c, along with c. If c is nil and the KV reads
+// the logfmt content, a new one is returned.
+func (kv *KV) matchesContent(m captainslog.SyslogMsg, c *logfmtContent) (bool, *logfmtContent) {
+	if c == nil && kv.Format.logfmt(m) {
+		c = &logfmtContent{}
+	}
+	return kv.match(m, c), c
+}
+
+// match returns true if the KV matches the supplied SyslogMsg, whose logfmt
+// content is parsed by c.
+func (kv *KV) match(m captainslog.SyslogMsg, c *logfmtContent) bool {
+	t := kv.compile(false)
+	return t.match(m, c)
+}
+
+// kvTest is a KV with its key path and the value it compares to resolved, so
+// it can be evaluated against many messages.
+type kvTest struct {
+	kv   *KV
+	path KeyPath
+	kind reflect.Kind
+	str  string
+	num  float64
+	b    bool
+	// re is the compiled regex of a string Regex match, if compiled.
+	re *regexp.Regexp
+}
+
+// compile returns the kvTest of the KV. If regex is true, the pattern of a
+// string Regex match is compiled as well.
+func (kv *KV) compile(regex bool) kvTest {
+	t := kvTest{kv: kv, path: kv.keyPath()}
+	v := reflect.ValueOf(kv.Value)
+	t.kind = v.Kind()
+	switch t.kind {
+	case reflect.String:
+		t.str = v.String()
+		if regex && kv.MatchType == Regex {
+			t.re, _ = regexp.Compile(t.str)
+		}
+	case reflect.Float64:
+		t.num = v.Float()
+	case reflect.Bool:
+		t.b = v.Bool()
+	}
+	return t
+}
+
+// match returns true if the KV matches the supplied SyslogMsg, whose logfmt
+// content is parsed by c.
+func (t *kvTest) match(m captainslog.SyslogMsg, c *logfmtContent) bool {
+	kv := t.kv
+	values, logfmt, ok := kv.Format.values(m, c)
+	if !ok || t.path == nil {
 		return false
 	}
 
-	keyChain := strings.Split(kv.Key, ".")
+	var val interface{}
+	coercion := kv.Coercion
+	if logfmt {
+		val, ok = lookupLogfmt(values, t.path)
+		coercion |= StringToNumber | StringToBool
+	} else {
+		val, ok = lookupKeyPath(values, t.path)
+	}
+	if !ok {
+		return false
+	}
+
+	switch t.kind {
+	case reflect.String:
+		if val, ok := asString(val, coercion); ok {
+			if t.re != nil {
+				return t.re.MatchString(val)
+			}
+			return compareString(kv.MatchType, val, t.str)
+		}
+	case reflect.Float64:
+		if val, ok := asFloat(val, coercion); ok {
+			return compareFloat(kv.MatchType, val, t.num)
+		}
+	case reflect.Bool:
+		if val, ok := asBool(val, coercion); ok {
+			return compareBool(kv.MatchType, val, t.b)
+		}
+	}
+
+	return false
+}
+
+// lookupKeyPath dereferences the key path in the supplied JSON values. It
+// returns false if any segment of the path is missing.
+func lookupKeyPath(values map[string]interface{}, p KeyPath) (interface{}, bool) {
 	var next interface{}
-	next = m.JSONValues
+	next = values
 
-	for _, key := range keyChain {
+	for _, key := range p {
 		typ := reflect.TypeOf(next)
-		if typ.Kind() != reflect.Map || typ.Key().Kind() != reflect.String || typ.Elem().Kind() != reflect.Interface {
-			return false
+		if typ == nil || typ.Kind() != reflect.Map || typ.Key().Kind() != reflect.String || typ.Elem().Kind() != reflect.Interface {
+			return nil, false
 		}
 		mapVal := reflect.ValueOf(next).MapIndex(reflect.ValueOf(key))
 		if !mapVal.IsValid() {
-			return false
+			return nil, false
 		}
 		next = mapVal.Interface()
 	}
 
-	v := reflect.ValueOf(next)
-	t := reflect.TypeOf(next)
-	kvr := reflect.ValueOf(kv.Value)
-
-	switch kvr.Kind() {
-	case reflect.String:
-		comp := kvr.String()
+	return next, true
+}
 
-		if v.Kind() != reflect.String {
-			return false
-		}
-		val := v.String()
-
-		switch kv.MatchType {
-		case ExactMatch, Equals:
-			return comp == val
-		case PrefixMatch:
-			return strings.HasPrefix(val, comp)
-		case Contains:
-			return strings.Contains(val, comp)
-		case Regex:
-			matched, _ := regexp.MatchString(comp, val)
-			return matched
-		}
-	case reflect.Float64:
-		comp := kvr.Float()
+// compareString returns true if val matches comp under the string match type.
+func compareString(t MatchType, val, comp string) bool {
+	switch t {
+	case ExactMatch, Equals:
+		return comp == val
+	case PrefixMatch:
+		return strings.HasPrefix(val, comp)
+	case Contains:
+		return strings.Contains(val, comp)
+	case Regex:
+		matched, _ := regexp.MatchString(comp, val)
+		return matched
+	}
 
-		var val float64
-		if t.String() == "json.Number" {
-			jsonNum, ok := v.Interface().(json.Number)
-			if !ok {
-				return false
-			}
-			tVal, err := jsonNum.Float64()
-			if err != nil {
-				return false
-			}
-			val = tVal
-		} else if v.Kind() == reflect.Float64 {
-			val = v.Float()
-		} else {
-			return false
-		}
+	return false
+}
 
-		switch kv.MatchType {
-		case Equals:
-			return comp == val
-		case LessThan:
-			return val < comp
-		case LessThanEqual:
-			return val <= comp
-		case GreaterThan:
-			return val > comp
-		case GreaterThanEqual:
-			return val >= comp
-		}
-	case reflect.Bool:
-		comp := kvr.Bool()
+// compareFloat returns true if val matches comp under the numeric match type.
+func compareFloat(t MatchType, val, comp float64) bool {
+	switch t {
+	case Equals:
+		return comp == val
+	case LessThan:
+		return val < comp
+	case LessThanEqual:
+		return val <= comp
+	case GreaterThan:
+		return val > comp
+	case GreaterThanEqual:
+		return val >= comp
+	}
 
-		if v.Kind() != reflect.Bool {
-			return false
-		}
-		val := v.Bool()
+	return false
+}
 
-		switch kv.MatchType {
-		case Equals:
-			return comp == val
-		}
+// compareBool returns true if val matches comp under the match type.
+func compareBool(t MatchType, val, comp bool) bool {
+	switch t {
+	case Equals:
+		return comp == val
 	}
 
 	return false
@@ -150,6 +258,7 @@ func (kv *KV) Decode(m map[string]interface{}) error {
 
 			if val, ok := v.(string); ok {
 				kv.Key = val
+				kv.path = parseKey(val)
 			} else {
 				return fmt.Errorf("failed to decode kv matcher, key is not a string")
 			}
@@ -177,6 +286,12 @@ func (kv *KV) Decode(m map[string]interface{}) error {
 					kv.Value = float64(val.Int())
 				case reflect.Float32, reflect.Float64:
 					kv.Value = val.Float()
+				case reflect.String:
+					if x, ok := parseVar(val.String(), NumberVar); ok {
+						kv.Value = x
+					} else {
+						foundValue = false
+					}
 				default:
 					foundValue = false
 				}
@@ -185,6 +300,25 @@ func (kv *KV) Decode(m map[string]interface{}) error {
 			if v != nil {
 				foundValue = true
 				kv.Value = v
+				if s, ok := v.(string); ok {
+					if x, ok := parseVar(s, BoolVar); ok {
+						kv.Value = x
+					}
+				}
+			}
+		case "coerce":
+			if v != nil {
+				if err := kv.Coercion.Decode(v); err != nil {
+					return err
+				}
+			}
+		case "format":
+			if f, ok := v.(string); ok {
+				if err := kv.Format.FromString(f); err != nil {
+					return err
+				}
+			} else if v != nil {
+				return fmt.Errorf("failed to decode kv matcher, format is not a string")
 			}
 		}
 	}
@@ -198,7 +332,7 @@ func (kv *KV) Decode(m map[string]interface{}) error {
 
 // Encode encodes a key-value object into a matcher map.
 func (kv *KV) Encode(out map[string]interface{}) {
-	out["key"] = kv.Key
+	out["key"] = kv.keyString()
 	out["match_type"] = kv.MatchType.String()
 
 	out["str_value"] = nil
@@ -213,4 +347,19 @@ func (kv *KV) Encode(out map[string]interface{}) {
 	case reflect.Bool:
 		out["bool_value"] = kv.Value
 	}
+	if v, ok := kv.Value.(Var); ok {
+		switch v.Type {
+		case NumberVar:
+			out["num_value"] = v.String()
+		case BoolVar:
+			out["bool_value"] = v.String()
+		}
+	}
+
+	if kv.Coercion != 0 {
+		out["coerce"] = kv.Coercion.Encode()
+	}
+	if kv.Format != DefaultContent {
+		out["format"] = kv.Format.String()
+	}
 }
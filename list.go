@@ -0,0 +1,314 @@
+package matcher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/digitalocean/captainslog"
+	"gopkg.in/yaml.v3"
+)
+
+// ListProvider resolves named lists of values, such as host inventories or
+// program allowlists maintained outside of rule files.
+type ListProvider interface {
+	List(name string) ([]string, error)
+}
+
+// StaticLists is a ListProvider serving lists from memory.
+type StaticLists map[string][]string
+
+// List returns the named list.
+func (s StaticLists) List(name string) ([]string, error) {
+	l, ok := s[name]
+	if !ok {
+		return nil, fmt.Errorf("failed to load list %q, no such list", name)
+	}
+	return l, nil
+}
+
+// FileListProvider is a ListProvider reading each list from a file in a
+// directory. The list named "hosts" is read from the first of hosts,
+// hosts.txt, hosts.json, hosts.yaml and hosts.yml which exists.
+type FileListProvider struct {
+	Dir string
+}
+
+// NewFileListProvider returns a new FileListProvider reading lists from the
+// specified directory.
+func NewFileListProvider(dir string) *FileListProvider {
+	return &FileListProvider{
+		Dir: dir,
+	}
+}
+
+// List reads the named list. See ParseList for the file formats.
+func (p *FileListProvider) List(name string) ([]string, error) {
+	if name == "" || name != filepath.Base(name) || name == ".." {
+		return nil, fmt.Errorf("failed to load list %q, invalid name", name)
+	}
+
+	for _, ext := range []string{"", ".txt", ".json", ".yaml", ".yml"} {
+		path := filepath.Join(p.Dir, name+ext)
+		b, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		l, err := ParseList(b, filepath.Ext(path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load list %q: %v", path, err)
+		}
+		return l, nil
+	}
+
+	return nil, fmt.Errorf("failed to load list %q, no such file in %s", name, p.Dir)
+}
+
+// ParseList parses a list file. A file with a .json, .yaml or .yml extension
+// holds an array of scalars; any other file holds one value per line, where
+// blank lines and lines starting with # are skipped.
+func ParseList(b []byte, ext string) ([]string, error) {
+	var items []interface{}
+	switch ext {
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		if err := d.Decode(&items); err != nil {
+			return nil, err
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(b, &items); err != nil {
+			return nil, err
+		}
+	default:
+		var out []string
+		s := bufio.NewScanner(bytes.NewReader(b))
+		for s.Scan() {
+			line := strings.TrimSpace(s.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			out = append(out, line)
+		}
+		return out, s.Err()
+	}
+
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			out = append(out, v)
+		case json.Number:
+			out = append(out, v.String())
+		case int:
+			out = append(out, strconv.Itoa(v))
+		case float64:
+			out = append(out, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			out = append(out, strconv.FormatBool(v))
+		default:
+			return nil, fmt.Errorf("list item %v is not a scalar", item)
+		}
+	}
+	return out, nil
+}
+
+// listSet is the set of values of a list, swapped atomically on reload.
+type listSet struct {
+	values atomic.Pointer[map[string]struct{}]
+}
+
+// store replaces the values of the set.
+func (s *listSet) store(l []string) {
+	values := make(map[string]struct{}, len(l))
+	for _, v := range l {
+		values[v] = struct{}{}
+	}
+	s.values.Store(&values)
+}
+
+// contains returns true if the value is in the set.
+func (s *listSet) contains(v string) bool {
+	_, ok := (*s.values.Load())[v]
+	return ok
+}
+
+// Lists loads the lists referenced by List matchers from a ListProvider, and
+// reloads them at runtime. Every List matcher resolved against a Lists sees
+// the values of the latest successful load.
+type Lists struct {
+	Provider ListProvider
+
+	mu   sync.Mutex
+	sets map[string]*listSet
+}
+
+// NewLists returns a new Lists loading lists from the specified provider.
+func NewLists(p ListProvider) *Lists {
+	return &Lists{
+		Provider: p,
+		sets:     make(map[string]*listSet),
+	}
+}
+
+// Resolve loads the list of every List matcher of the tree which is not yet
+// loaded, and attaches it to the matcher. The tree may be matched while it is
+// resolved.
+func (l *Lists) Resolve(m Matcher) error {
+	var err error
+	Walk(m, func(node Matcher, path Path) bool {
+		if err != nil {
+			return false
+		}
+		if o, ok := node.(*List); ok {
+			var s *listSet
+			if s, err = l.load(o.Name); err == nil {
+				o.set.Store(s)
+			}
+		}
+		return true
+	})
+	return err
+}
+
+// load returns the set of the named list, loading it from the provider the
+// first time.
+func (l *Lists) load(name string) (*listSet, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if s, ok := l.sets[name]; ok {
+		return s, nil
+	}
+	values, err := l.Provider.List(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &listSet{}
+	s.store(values)
+	l.sets[name] = s
+	return s, nil
+}
+
+// Reload loads every list again. The lists are swapped only if all of them
+// load, so a failed reload leaves the previous values in place.
+func (l *Lists) Reload() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	names := make([]string, 0, len(l.sets))
+	for name := range l.sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	loaded := make([][]string, len(names))
+	for i, name := range names {
+		values, err := l.Provider.List(name)
+		if err != nil {
+			return err
+		}
+		loaded[i] = values
+	}
+	for i, name := range names {
+		l.sets[name].store(loaded[i])
+	}
+	return nil
+}
+
+// List represents a matcher of the messages whose field value is in a named
+// list. The list is loaded by Lists.Resolve; an unresolved List never
+// matches.
+type List struct {
+	Field Field
+	Name  string
+
+	// set is the loaded list, set by Lists.Resolve.
+	set atomic.Pointer[listSet]
+}
+
+// NewList returns a new List matching the specified field against the named
+// list.
+func NewList(f Field, n string) *List {
+	return &List{
+		Field: f,
+		Name:  n,
+	}
+}
+
+// String converts a List to its corresponding string representation.
+func (l *List) String() string {
+	return fmt.Sprintf("list(%s, %q)", l.Field, l.Name)
+}
+
+// Matches returns true if the field value of the supplied SyslogMsg is in the
+// list. Numbers are compared by their decimal representation.
+func (l *List) Matches(m captainslog.SyslogMsg) bool {
+	set := l.set.Load()
+	if set == nil {
+		return false
+	}
+	v, ok := l.Field.value(m)
+	if !ok {
+		return false
+	}
+	s, ok := asString(v, NumberToString)
+	if !ok {
+		return false
+	}
+	return set.contains(s)
+}
+
+// Decode decodes a matcher map into a List type.
+func (l *List) Decode(m map[string]interface{}) error {
+	foundField := false
+	foundName := false
+	for k, v := range m {
+		switch k {
+		case "field":
+			foundField = true
+
+			if f, ok := v.(map[string]interface{}); ok {
+				if err := l.Field.Decode(f); err != nil {
+					return err
+				}
+			} else {
+				return fmt.Errorf("failed to decode list matcher, field is not a map")
+			}
+		case "name":
+			foundName = true
+
+			if n, ok := v.(string); ok {
+				l.Name = n
+			} else {
+				return fmt.Errorf("failed to decode list matcher, name is not a string")
+			}
+		}
+	}
+
+	if !(foundField && foundName) {
+		return fmt.Errorf("failed to decode list matcher, missing fields")
+	}
+
+	return nil
+}
+
+// Encode encodes a List into a matcher map.
+func (l *List) Encode(out map[string]interface{}) {
+	field := make(map[string]interface{})
+	l.Field.Encode(field)
+
+	out["field"] = field
+	out["name"] = l.Name
+}
@@ -0,0 +1,187 @@
+package matcher
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestParseList(t *testing.T) {
+	want := []string{"web-1", "web-2", "500"}
+	for _, tc := range []struct {
+		ext  string
+		data string
+	}{
+		{"", "# inventory\nweb-1\n\n  web-2  \n500\n"},
+		{".json", `["web-1", "web-2", 500]`},
+		{".yaml", "- web-1\n- web-2\n- 500\n"},
+	} {
+		got, err := ParseList([]byte(tc.data), tc.ext)
+		if err != nil {
+			t.Fatalf("failed to parse %q list: %v", tc.ext, err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("want != got, want = %v, got = %v", want, got)
+		}
+	}
+
+	if _, err := ParseList([]byte(`[["nested"]]`), ".json"); err == nil {
+		t.Errorf("nested list should not parse")
+	}
+}
+
+func TestFileListProvider(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hosts.txt"), []byte("web-1\nweb-2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "programs.yml"), []byte("[cron, sshd]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewFileListProvider(dir)
+	for name, want := range map[string][]string{
+		"hosts":    {"web-1", "web-2"},
+		"programs": {"cron", "sshd"},
+	} {
+		got, err := p.List(name)
+		if err != nil {
+			t.Fatalf("failed to load list %q: %v", name, err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("want != got, want = %v, got = %v", want, got)
+		}
+	}
+
+	for _, name := range []string{"missing", "../hosts", ""} {
+		if _, err := p.List(name); err == nil {
+			t.Errorf("list %q should not load", name)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	lists := StaticLists{
+		"staging": {"staging-1", "staging-2"},
+		"codes":   {"500", "503"},
+	}
+	l := NewLists(lists)
+
+	in := map[string]interface{}{
+		"n_ary_op": map[string]interface{}{
+			"type": "and",
+			"matchers": []interface{}{
+				map[string]interface{}{"list_matcher": map[string]interface{}{
+					"field": map[string]interface{}{"type": "host"},
+					"name":  "staging",
+				}},
+				map[string]interface{}{"list_matcher": map[string]interface{}{
+					"field": map[string]interface{}{"type": "kv", "key": "status"},
+					"name":  "codes",
+				}},
+			},
+		},
+	}
+	rule, err := Decode(in)
+	if err != nil {
+		t.Fatalf("failed to decode rule: %v", err)
+	}
+	if want, got := `(list(host, "staging") and list(kv("status"), "codes"))`, rule.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	m := captainslog.NewSyslogMsg()
+	m.Host = "staging-2"
+	m.IsJSON = true
+	m.JSONValues = map[string]interface{}{"status": 503.0}
+
+	if rule.Matches(m) {
+		t.Errorf("unresolved list matched")
+	}
+	if err := l.Resolve(rule); err != nil {
+		t.Fatalf("failed to resolve lists: %v", err)
+	}
+	if !rule.Matches(m) {
+		t.Errorf("%s did not match", rule)
+	}
+
+	// A failed reload keeps the previous lists.
+	delete(lists, "codes")
+	lists["staging"] = []string{"staging-3"}
+	if err := l.Reload(); err == nil {
+		t.Errorf("reload of a missing list should fail")
+	}
+	if !rule.Matches(m) {
+		t.Errorf("%s did not match after a failed reload", rule)
+	}
+
+	lists["codes"] = []string{"500"}
+	if err := l.Reload(); err != nil {
+		t.Fatalf("failed to reload lists: %v", err)
+	}
+	if rule.Matches(m) {
+		t.Errorf("%s matched after reload", rule)
+	}
+
+	if err := l.Resolve(NewList(NewField(HostField, ""), "missing")); err == nil {
+		t.Errorf("resolving a missing list should fail")
+	}
+}
+
+func TestListReloadRace(t *testing.T) {
+	l := NewLists(StaticLists{"hosts": {"a"}})
+	rule := NewList(NewField(HostField, ""), "hosts")
+	if err := l.Resolve(rule); err != nil {
+		t.Fatal(err)
+	}
+
+	m := captainslog.NewSyslogMsg()
+	m.Host = "a"
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			rule.Matches(m)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if err := l.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestListResolveRace(t *testing.T) {
+	rule := NewList(NewField(HostField, ""), "hosts")
+
+	m := captainslog.NewSyslogMsg()
+	m.Host = "a"
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			rule.Matches(m)
+		}
+	}()
+	// The rule is resolved again, e.g. against new lists after a reload,
+	// while it is matched.
+	for i := 0; i < 100; i++ {
+		if err := NewLists(StaticLists{"hosts": {"a"}}).Resolve(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	if want, got := true, rule.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
@@ -0,0 +1,241 @@
+package matcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// Loader loads rules from a YAML or JSON rule file, or from a directory tree
+// of rule files as LoadDir does, and reloads them when the files change. The
+// rules are swapped atomically, so matching never blocks on a reload and
+// always sees a complete rule set. A reload which fails keeps the last good
+// rules.
+type Loader struct {
+	// Path is the rule file or directory.
+	Path string
+	// Interval is how often the modification times of the files are polled.
+	Interval time.Duration
+	// Validate, if set, is called with the decoded rules before they are
+	// swapped in. Returning an error rejects them.
+	Validate func(Matchers) error
+	// OnError, if set, is called with the errors of reloads done while
+	// polling.
+	OnError func(error)
+	// ContentFormat, if set, is the format of the KV matchers of the rules
+	// which use the DefaultContent format.
+	ContentFormat ContentFormat
+
+	rules atomic.Pointer[Matchers]
+
+	mu        sync.Mutex
+	signature string
+	// failed is the signature of the files which last failed to load, with
+	// the error, so unchanged broken files are not decoded again.
+	failed string
+	err    error
+	// read is the files read by the last load, including the files they
+	// include.
+	read []string
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewLoader returns a new Loader polling the rule file or directory at the
+// specified interval, and reporting reload errors to onError.
+func NewLoader(path string, interval time.Duration, onError func(error)) *Loader {
+	return &Loader{
+		Path:     path,
+		Interval: interval,
+		OnError:  onError,
+	}
+}
+
+// Rules returns the current rules, or nil if none were loaded yet.
+func (l *Loader) Rules() Matchers {
+	if rules := l.rules.Load(); rules != nil {
+		return *rules
+	}
+	return nil
+}
+
+// Matches returns true if any of the current rules matches the supplied
+// SyslogMsg.
+func (l *Loader) Matches(m captainslog.SyslogMsg) bool {
+	return l.Rules().Matches(m)
+}
+
+// Load loads the rules now, and swaps them in if they decode and validate.
+func (l *Loader) Load() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sig, err := l.signatureOf()
+	if err != nil {
+		return err
+	}
+	return l.load(sig)
+}
+
+// load decodes, validates and swaps in the rules, and records the signature
+// of the files. The caller holds mu.
+func (l *Loader) load(sig string) error {
+	rules, err := l.decode()
+	if err != nil {
+		l.failed, l.err = sig, err
+		return err
+	}
+
+	l.rules.Store(&rules)
+	l.signature = sig
+	l.failed, l.err = "", nil
+	return nil
+}
+
+// decode decodes and validates the rules.
+func (l *Loader) decode() (Matchers, error) {
+	rules, read, err := loadRules(l.Path)
+	if read != nil {
+		l.read = read
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if l.ContentFormat != DefaultContent {
+		for i, r := range rules {
+			rules[i] = WithContentFormat(r, l.ContentFormat)
+		}
+	}
+
+	if l.Validate != nil {
+		if err := l.Validate(rules); err != nil {
+			return nil, fmt.Errorf("failed to validate rules from %s: %v", l.Path, err)
+		}
+	}
+	return rules, nil
+}
+
+// Reload loads the rules if the files changed since the last successful load.
+// If the files did not change since they last failed to load, it returns the
+// same error again.
+func (l *Loader) Reload() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sig, err := l.signatureOf()
+	if err != nil {
+		return err
+	}
+	switch sig {
+	case l.signature:
+		return nil
+	case l.failed:
+		return l.err
+	}
+	return l.load(sig)
+}
+
+// Start loads the rules, then polls for changes in the background until Stop
+// is called. It returns the error of the initial load, in which case polling
+// is not started.
+func (l *Loader) Start() error {
+	if err := l.Load(); err != nil {
+		return err
+	}
+
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go l.poll(l.stop, l.done)
+	return nil
+}
+
+// Stop stops polling and waits for a reload in progress to finish.
+func (l *Loader) Stop() {
+	if l.stop == nil {
+		return
+	}
+	close(l.stop)
+	<-l.done
+	l.stop = nil
+}
+
+func (l *Loader) poll(stop, done chan struct{}) {
+	defer close(done)
+
+	t := time.NewTicker(l.Interval)
+	defer t.Stop()
+
+	// reported is the last error passed to OnError, which is not reported
+	// again until it changes.
+	var reported string
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			err := l.Reload()
+			if err == nil {
+				reported = ""
+				continue
+			}
+			if err.Error() != reported && l.OnError != nil {
+				l.OnError(err)
+			}
+			reported = err.Error()
+		}
+	}
+}
+
+// signatureOf returns a signature of the names, sizes and modification times
+// of the rule files of the Path, and of the files they included when last
+// loaded, which changes when any of them does. The caller holds mu.
+func (l *Loader) signatureOf() (string, error) {
+	fi, err := os.Stat(l.Path)
+	if err != nil {
+		return "", err
+	}
+
+	files := []string{l.Path}
+	if fi.IsDir() {
+		if files, err = ruleFiles(l.Path); err != nil {
+			return "", err
+		}
+	}
+	files = append(files, l.read...)
+	sort.Strings(files)
+
+	var sig strings.Builder
+	for i, f := range files {
+		if i > 0 && f == files[i-1] {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if os.IsNotExist(err) {
+			// A removed include changes the signature of its file.
+			fmt.Fprintf(&sig, "%s:missing\n", f)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sig, "%s:%d:%d\n", f, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return sig.String(), nil
+}
+
+// isRuleFile returns true if the file name has a YAML or JSON extension.
+func isRuleFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package matcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+const (
+	cronRules = `
+- value_matcher:
+    type: program
+    match_type: exact_match
+    value: cron
+`
+	sshdRules = `
+- value_matcher:
+    type: program
+    match_type: exact_match
+    value: sshd
+`
+)
+
+// writeRules writes the rule file and moves its modification time forward,
+// so that the change is seen even on file systems with coarse timestamps.
+func writeRules(t *testing.T, path, data string, age time.Duration) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRules(t, path, cronRules, time.Hour)
+
+	l := NewLoader(path, time.Hour, nil)
+	if l.Rules() != nil {
+		t.Errorf("rules before the first load should be nil")
+	}
+	if err := l.Load(); err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+
+	m := captainslog.NewSyslogMsg()
+	m.Tag.Program = "sshd"
+	if l.Matches(m) {
+		t.Errorf("loaded rules %v should not match", l.Rules())
+	}
+
+	writeRules(t, path, sshdRules, time.Minute)
+	if err := l.Reload(); err != nil {
+		t.Fatalf("failed to reload rules: %v", err)
+	}
+	if !l.Matches(m) {
+		t.Errorf("reloaded rules %v should match", l.Rules())
+	}
+
+	// Invalid rules are rejected and the last good rules are kept.
+	writeRules(t, path, "- unknown_matcher: {}\n", 0)
+	if err := l.Reload(); err == nil {
+		t.Errorf("reload of invalid rules should fail")
+	}
+	if !l.Matches(m) {
+		t.Errorf("rules %v should be kept after a failed reload", l.Rules())
+	}
+
+	l.Validate = func(ms Matchers) error { return errors.New("rejected") }
+	writeRules(t, path, cronRules, 0)
+	if err := l.Reload(); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("want validation error, got %v", err)
+	}
+	if !l.Matches(m) {
+		t.Errorf("rules %v should be kept after a rejected reload", l.Rules())
+	}
+}
+
+func TestLoaderContentFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRules(t, path, `
+- kv_matcher:
+    key: level
+    match_type: exact_match
+    str_value: warn
+`, time.Hour)
+
+	m := captainslog.NewSyslogMsg()
+	m.Content = "level=warn"
+
+	l := NewLoader(path, time.Hour, nil)
+	if err := l.Load(); err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+	if l.Matches(m) {
+		t.Errorf("rules %v should not match logfmt content", l.Rules())
+	}
+
+	l = NewLoader(path, time.Hour, nil)
+	l.ContentFormat = LogfmtContent
+	if err := l.Load(); err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+	if !l.Matches(m) {
+		t.Errorf("rules %v should match logfmt content", l.Rules())
+	}
+}
+
+func TestLoaderDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeRules(t, filepath.Join(dir, "a.yaml"), cronRules, time.Hour)
+	writeRules(t, filepath.Join(dir, "b.json"), `[{"constant_matcher": {"value": false}}]`, time.Hour)
+	writeRules(t, filepath.Join(dir, "README.md"), "not rules", time.Hour)
+
+	l := NewLoader(dir, time.Hour, nil)
+	if err := l.Load(); err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+	if want, got := `[program(exact_match, "cron") false]`, stringsOf(l.Rules()); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func stringsOf(ms Matchers) string {
+	var out []string
+	for _, m := range ms {
+		out = append(out, m.String())
+	}
+	return "[" + strings.Join(out, " ") + "]"
+}
+
+func TestLoaderPolling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRules(t, path, cronRules, time.Hour)
+
+	errs := make(chan error, 10)
+	l := NewLoader(path, 5*time.Millisecond, func(err error) { errs <- err })
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start loader: %v", err)
+	}
+	defer l.Stop()
+
+	m := captainslog.NewSyslogMsg()
+	m.Tag.Program = "sshd"
+
+	writeRules(t, path, sshdRules, time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for !l.Matches(m) {
+		if time.Now().After(deadline) {
+			t.Fatalf("rules were not reloaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	writeRules(t, path, "- [", 0)
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("error %q should name the rule file", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("reload error was not reported")
+	}
+	if !l.Matches(m) {
+		t.Errorf("rules %v should be kept after a failed reload", l.Rules())
+	}
+
+	// The error is reported once until the file changes.
+	time.Sleep(50 * time.Millisecond)
+	if n := len(errs); n != 0 {
+		t.Errorf("want the error reported once, got %d more reports", n)
+	}
+}
+
+func TestLoaderInclude(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.yaml")
+	included := filepath.Join(dir, "extra.rules")
+	writeRules(t, path, "include: extra.rules\n", time.Hour)
+	writeRules(t, included, cronRules, time.Hour)
+
+	l := NewLoader(path, time.Hour, nil)
+	if err := l.Load(); err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+
+	m := captainslog.NewSyslogMsg()
+	m.Tag.Program = "sshd"
+	if l.Matches(m) {
+		t.Errorf("loaded rules %v should not match", l.Rules())
+	}
+
+	// A change of an included file is picked up.
+	writeRules(t, included, sshdRules, time.Minute)
+	if err := l.Reload(); err != nil {
+		t.Fatalf("failed to reload rules: %v", err)
+	}
+	if !l.Matches(m) {
+		t.Errorf("reloaded rules %v should match", l.Rules())
+	}
+}
@@ -81,6 +81,30 @@ func Decode(m map[string]interface{}) (Matcher, error) {
 			hostname := &Hostname{}
 			err := hostname.Decode(matcher)
 			return hostname, err
+		case "field_compare_matcher":
+			compare := &FieldCompare{}
+			err := compare.Decode(matcher)
+			return compare, err
+		case "capture_matcher":
+			capture := &Capture{}
+			err := capture.Decode(matcher)
+			return capture, err
+		case "constant_matcher":
+			constant := &Constant{}
+			err := constant.Decode(matcher)
+			return constant, err
+		case "rule":
+			rule := &Rule{}
+			err := rule.Decode(matcher)
+			return rule, err
+		case "list_matcher":
+			list := &List{}
+			err := list.Decode(matcher)
+			return list, err
+		case "ref_matcher":
+			ref := &Ref{}
+			err := ref.Decode(matcher)
+			return ref, err
 		}
 	}
 
@@ -114,6 +138,30 @@ func Encode(in Matcher, out map[string]interface{}) {
 		out["value_matcher"] = make(map[string]interface{})
 		m := in.(*Value)
 		m.Encode(out["value_matcher"].(map[string]interface{}))
+	case *FieldCompare:
+		out["field_compare_matcher"] = make(map[string]interface{})
+		m := in.(*FieldCompare)
+		m.Encode(out["field_compare_matcher"].(map[string]interface{}))
+	case *Capture:
+		out["capture_matcher"] = make(map[string]interface{})
+		m := in.(*Capture)
+		m.Encode(out["capture_matcher"].(map[string]interface{}))
+	case *Constant:
+		out["constant_matcher"] = make(map[string]interface{})
+		m := in.(*Constant)
+		m.Encode(out["constant_matcher"].(map[string]interface{}))
+	case *Rule:
+		out["rule"] = make(map[string]interface{})
+		m := in.(*Rule)
+		m.Encode(out["rule"].(map[string]interface{}))
+	case *List:
+		out["list_matcher"] = make(map[string]interface{})
+		m := in.(*List)
+		m.Encode(out["list_matcher"].(map[string]interface{}))
+	case *Ref:
+		out["ref_matcher"] = make(map[string]interface{})
+		m := in.(*Ref)
+		m.Encode(out["ref_matcher"].(map[string]interface{}))
 	case *UnaryOp:
 		out["unary_op"] = make(map[string]interface{})
 		m := in.(*UnaryOp)
@@ -122,5 +170,7 @@ func Encode(in Matcher, out map[string]interface{}) {
 		out["n_ary_op"] = make(map[string]interface{})
 		m := in.(*NAryOp)
 		m.Encode(out["n_ary_op"].(map[string]interface{}))
+	case *AdaptiveOp:
+		Encode(Freeze(in), out)
 	}
 }
@@ -2,10 +2,12 @@ package matcher
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/digitalocean/captainslog"
+	"gopkg.in/yaml.v3"
 )
 
 func TestNewValue(t *testing.T) {
@@ -504,3 +506,581 @@ func TestHostnameMatcher(t *testing.T) {
 		t.Errorf("want != got, want = %v, got = %v", want, got)
 	}
 }
+
+func TestHostnameMatcherDecode(t *testing.T) {
+	out := make(map[string]interface{})
+	Encode(NewHostname(PrefixMatch, "web-"), out)
+	decoded, err := Decode(out)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+
+	h, ok := decoded.(*Hostname)
+	if !ok {
+		t.Fatalf("want *Hostname, got %T", decoded)
+	}
+	if want, got := "web-", h.NameMatcher; want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	m := captainslog.NewSyslogMsg()
+	m.Host = "db-1"
+	if want, got := false, decoded.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	m.Host = "web-1"
+	if want, got := true, decoded.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	if err := (&Hostname{}).Decode(map[string]interface{}{"match_type": "prefix_match", "hostname": 1}); err == nil {
+		t.Errorf("decoding a non-string hostname did not return an error")
+	}
+}
+
+func TestParseKeyPath(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want KeyPath
+		str  string
+	}{
+		{`foo`, KeyPath{"foo"}, `foo`},
+		{`foo.bar.baz`, KeyPath{"foo", "bar", "baz"}, `foo.bar.baz`},
+		{`labels.app\.kubernetes\.io/name`, KeyPath{"labels", "app.kubernetes.io/name"}, `labels["app.kubernetes.io/name"]`},
+		{`labels["app.kubernetes.io/name"]`, KeyPath{"labels", "app.kubernetes.io/name"}, `labels["app.kubernetes.io/name"]`},
+		{`["a.b"].c`, KeyPath{"a.b", "c"}, `["a.b"].c`},
+		{`a["b"]["c\"d"]`, KeyPath{"a", "b", "c\"d"}, `a.b["c\"d"]`},
+		{`a.["b"]`, KeyPath{"a", "b"}, `a.b`},
+	} {
+		got, err := ParseKeyPath(c.in)
+		if err != nil {
+			t.Errorf("ParseKeyPath(%q) returned error: %v", c.in, err)
+			continue
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("want != got, want = %q, got = %q", c.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("want != got, want = %q, got = %q", c.want, got)
+				break
+			}
+		}
+		if want, got := c.str, got.String(); want != got {
+			t.Errorf("want != got, want = %v, got = %v", want, got)
+		}
+	}
+
+	for _, in := range []string{``, `.a`, `a.`, `a..b`, `a\`, `a["b"`, `a[b]`, `a["b"]c`, `a]`, `a"b`} {
+		if _, err := ParseKeyPath(in); err == nil {
+			t.Errorf("ParseKeyPath(%q) did not return an error", in)
+		}
+	}
+}
+
+func TestKVMatcherQuotedKey(t *testing.T) {
+	kvm := NewKV(`labels["app.kubernetes.io/name"]`, ExactMatch, "api")
+
+	m := captainslog.NewSyslogMsg()
+	m.IsJSON = true
+	m.JSONValues = make(map[string]interface{})
+	_ = json.Unmarshal([]byte(`{"labels":{"app.kubernetes.io/name":"api"}}`), &m.JSONValues)
+
+	if want, got := true, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	kvm = NewKV(`labels.app\.kubernetes\.io/name`, ExactMatch, "api")
+	if want, got := true, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	kvm = NewKV(`labels.app.kubernetes.io/name`, ExactMatch, "api")
+	if want, got := false, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	if want, got := `kv("labels[\"app.kubernetes.io/name\"]", exact_match, "api")`, NewKV(`labels.app\.kubernetes\.io/name`, ExactMatch, "api").String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	out := make(map[string]interface{})
+	Encode(NewKV(`labels.app\.kubernetes\.io/name`, ExactMatch, "api"), out)
+	decoded, err := Decode(out)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if want, got := true, decoded.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+}
+
+func TestKVMatcherLiteralKey(t *testing.T) {
+	m := captainslog.NewSyslogMsg()
+	m.IsJSON = true
+	m.JSONValues = make(map[string]interface{})
+	_ = json.Unmarshal([]byte(`{"items[0]":"a","x":{"":{"y":"b"}},"a[\"b":"c"}`), &m.JSONValues)
+
+	for _, c := range []struct {
+		key   string
+		value string
+	}{
+		{`items[0]`, "a"},
+		{`x..y`, "b"},
+		{`a["b`, "c"},
+	} {
+		var v map[string]interface{}
+		if err := yaml.Unmarshal([]byte(fmt.Sprintf("{key: %q, match_type: exact_match, str_value: %s}", c.key, c.value)), &v); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := Decode(map[string]interface{}{"kv_matcher": v})
+		if err != nil {
+			t.Errorf("failed to decode key %q: %v", c.key, err)
+			continue
+		}
+		if want, got := true, decoded.Matches(m); want != got {
+			t.Errorf("%s: want != got, want = %v, got = %v", decoded, want, got)
+		}
+		if want, got := true, NewKV(c.key, ExactMatch, c.value).Matches(m); want != got {
+			t.Errorf("%s: want != got, want = %v, got = %v", c.key, want, got)
+		}
+
+		out := make(map[string]interface{})
+		Encode(decoded, out)
+		if redecoded, err := Decode(out); err != nil || !redecoded.Matches(m) {
+			t.Errorf("%s: failed to round trip, err = %v", decoded, err)
+		}
+	}
+}
+
+func TestKVMatcherCoercion(t *testing.T) {
+	m := captainslog.NewSyslogMsg()
+	m.IsJSON = true
+	m.JSONValues = make(map[string]interface{})
+	m.JSONValues["status"] = "500"
+	m.JSONValues["ok"] = "true"
+	m.JSONValues["code"] = float64(404)
+	m.JSONValues["num"] = json.Number("12")
+
+	kvm := NewKV("status", GreaterThanEqual, 500)
+	if want, got := false, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	kvm.Coercion = StringToNumber
+	if want, got := true, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	kvm = NewKV("ok", Equals, true)
+	if want, got := false, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	kvm.Coercion = StringToNumber
+	if want, got := false, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	kvm.Coercion = StringToNumber | StringToBool
+	if want, got := true, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	kvm = NewKV("code", PrefixMatch, "40")
+	if want, got := false, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	kvm.Coercion = NumberToString
+	if want, got := true, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	kvm = NewKV("num", Equals, 12)
+	if want, got := true, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	m.JSONValues["status"] = "NaN"
+	kvm = NewKV("status", LessThan, 500)
+	kvm.Coercion = StringToNumber
+	if want, got := false, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	if want, got := `kv("status", lt, 500, coerce="string_to_number")`, kvm.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	out := make(map[string]interface{})
+	kvm.Coercion = StringToNumber | NumberToString
+	Encode(kvm, out)
+	decoded, err := Decode(out)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if want, got := StringToNumber|NumberToString, decoded.(*KV).Coercion; want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	in := map[string]interface{}{"key": "a", "match_type": "equals", "bool_value": true, "coerce": []interface{}{"string_to_json"}}
+	if err := (&KV{}).Decode(in); err == nil {
+		t.Errorf("decoding an invalid coercion did not return an error")
+	}
+}
+
+func TestFieldCompareMatcher(t *testing.T) {
+	m := captainslog.NewSyslogMsg()
+	m.Host = "api.example.com"
+	m.IsJSON = true
+	m.JSONValues = make(map[string]interface{})
+	_ = json.Unmarshal([]byte(`{
+		"bytes_sent": 100,
+		"bytes_expected": 120,
+		"limit": "100",
+		"request": {"host": "api.example.com"},
+		"tls": {"sni": "www.example.com"}
+	}`), &m.JSONValues)
+
+	c := NewFieldCompare(NewField(KeyField, "bytes_sent"), GreaterThan, NewField(KeyField, "bytes_expected"))
+	if want, got := false, c.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	c.MatchType = LessThan
+	if want, got := true, c.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	c = NewFieldCompare(NewField(KeyField, "request.host"), Equals, NewField(KeyField, "tls.sni"))
+	if want, got := false, c.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	c = NewFieldCompare(NewField(HostField, ""), ExactMatch, NewField(KeyField, "request.host"))
+	if want, got := true, c.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	c = NewFieldCompare(NewField(KeyField, "bytes_sent"), Equals, NewField(KeyField, "limit"))
+	if want, got := false, c.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	c.Coercion = StringToNumber
+	if want, got := true, c.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	c = NewFieldCompare(NewField(KeyField, "bytes_sent"), Equals, NewField(KeyField, "missing"))
+	if want, got := false, c.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	c = NewFieldCompare(NewField(HostField, ""), PrefixMatch, NewField(KeyField, "tls.sni"))
+	if want, got := `compare(host, prefix_match, kv("tls.sni"))`, c.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	out := make(map[string]interface{})
+	Encode(NewFieldCompare(NewField(KeyField, "bytes_sent"), LessThan, NewField(KeyField, "bytes_expected")), out)
+	decoded, err := Decode(out)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if want, got := true, decoded.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	in := map[string]interface{}{
+		"left":       map[string]interface{}{"type": "kv"},
+		"match_type": "equals",
+		"right":      map[string]interface{}{"type": "host"},
+	}
+	if err := (&FieldCompare{}).Decode(in); err == nil {
+		t.Errorf("decoding a kv field without a key did not return an error")
+	}
+}
+
+func TestCaptureMatcher(t *testing.T) {
+	m := captainslog.NewSyslogMsg()
+	m.Content = "GET /index.html took 1532ms status=200"
+
+	c := NewCapture(NewField(ContentField, ""), `took (?P<ms>\d+)ms`, "ms", GreaterThan, 1000)
+	if want, got := true, c.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	c.MatchType = LessThanEqual
+	if want, got := false, c.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	c = NewCapture(NewField(ContentField, ""), `status=(\d+)`, "", Equals, 200)
+	if want, got := true, c.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	c = NewCapture(NewField(ContentField, ""), `took (?P<took>\S+)`, "took", GreaterThanEqual, 1500*time.Millisecond)
+	if want, got := true, c.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	c.Value = 2 * time.Second
+	if want, got := false, c.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	m.Content = "GET /index.html took forever"
+	if want, got := false, c.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	m.Content = "GET /index.html"
+	if want, got := false, c.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	if want, got := `capture(content, "took (?P<took>\\S+)", "took", gte, duration("2s"))`, c.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	out := make(map[string]interface{})
+	Encode(c, out)
+	decoded, err := Decode(out)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	m.Content = "took 3s"
+	if want, got := true, decoded.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	in := map[string]interface{}{
+		"field":      map[string]interface{}{"type": "content"},
+		"pattern":    `took (\d+)ms`,
+		"group":      "ms",
+		"match_type": "gt",
+		"num_value":  1000,
+	}
+	if err := (&Capture{}).Decode(in); err == nil {
+		t.Errorf("decoding a capture with a missing group did not return an error")
+	}
+}
+
+func TestParseLogfmt(t *testing.T) {
+	got := ParseLogfmt(`level=info msg="request done" took=15ms debug request.host=api "ignored" esc="a \"b\"" open="unterminated`)
+
+	want := map[string]interface{}{
+		"level":        "info",
+		"msg":          "request done",
+		"took":         "15ms",
+		"debug":        true,
+		"request.host": "api",
+		"esc":          `a "b"`,
+		"open":         "unterminated",
+	}
+	if len(got) != len(want) {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("want != got for key %q, want = %v, got = %v", k, v, got[k])
+		}
+	}
+}
+
+func TestKVMatcherLogfmt(t *testing.T) {
+	m := captainslog.NewSyslogMsg()
+	m.Content = `level=warn status=503 cached=false request.host=api.example.com`
+
+	kvm := NewKV("status", GreaterThanEqual, 500)
+	if want, got := false, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	kvm.Format = LogfmtContent
+	if want, got := true, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	kvm = NewKV("cached", Equals, false)
+	kvm.Format = AutoContent
+	if want, got := true, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	kvm = NewKV("request.host", PrefixMatch, "api.")
+	kvm.Format = LogfmtContent
+	if want, got := true, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	// Auto uses the JSON values of JSON messages.
+	m.IsJSON = true
+	m.JSONValues["level"] = "error"
+	kvm = NewKV("level", ExactMatch, "error")
+	kvm.Format = AutoContent
+	if want, got := true, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	m.IsJSON = false
+
+	kvm = NewKV("level", ExactMatch, "warn")
+	if want, got := false, kvm.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	x := NewKV("x", ExactMatch, "1")
+	x.Format = JSONContent
+	rule := WithContentFormat(NewNAryOp(And, kvm, x), LogfmtContent)
+	if want, got := `(kv("level", exact_match, "warn", format="logfmt") and kv("x", exact_match, "1", format="json"))`, rule.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := DefaultContent, kvm.Format; want != got {
+		t.Errorf("WithContentFormat modified its input, want = %v, got = %v", want, got)
+	}
+	if want, got := true, rule.(*NAryOp).Matchers[0].Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	kvm.Format = LogfmtContent
+	if want, got := `kv("level", exact_match, "warn", format="logfmt")`, kvm.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	out := make(map[string]interface{})
+	Encode(kvm, out)
+	decoded, err := Decode(out)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if want, got := LogfmtContent, decoded.(*KV).Format; want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestLogfmtContent(t *testing.T) {
+	var c logfmtContent
+	a := c.values("a=1")
+	a["b"] = "2"
+	if want, got := "2", c.values("a=1")["b"]; want != got {
+		t.Errorf("content was parsed again, want = %v, got = %v", want, got)
+	}
+
+	// Every matcher of a rule set sees the content parsed for the first.
+	m := captainslog.NewSyslogMsg()
+	m.Content = "level=warn status=503"
+	level := NewKV("level", ExactMatch, "warn")
+	level.Format = LogfmtContent
+	status := NewKV("status", GreaterThanEqual, 500.0)
+	status.Format = LogfmtContent
+	rules := Matchers{NewUnaryOp(Not, level), NewNAryOp(And, level, status)}
+	if want, got := true, rules.Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := false, (Matchers{NewUnaryOp(Not, level)}).Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestMatchesAllocs(t *testing.T) {
+	m := captainslog.NewSyslogMsg()
+	m.Host = "web-1"
+	m.SetProgram("nginx")
+
+	// Evaluations which never read logfmt content allocate nothing.
+	host := NewHostname(PrefixMatch, "web-")
+	op := NewNAryOp(And, host, NewUnaryOp(Not, NewValue(Program, ExactMatch, "cron")))
+	for _, matcher := range []Matcher{
+		op,
+		NewUnaryOp(Not, host),
+		NewRule("r", op),
+		NewRef("d", op),
+		NewAdaptiveOp(Or, 0, host, op),
+	} {
+		if want, got := 0.0, testing.AllocsPerRun(100, func() { matcher.Matches(m) }); want != got {
+			t.Errorf("%s: want != got, want = %v, got = %v", matcher, want, got)
+		}
+	}
+
+	rules := Matchers{NewValue(Program, ExactMatch, "cron"), op}
+	if want, got := 0.0, testing.AllocsPerRun(100, func() { rules.Matches(m) }); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestThresholdOps(t *testing.T) {
+	if want, got := 1, int(Or); want != got {
+		t.Errorf("existing NAryOpType values changed, want = %v, got = %v", want, got)
+	}
+
+	a := NewHostname(ExactMatch, "foo")
+	b := NewValue(Program, ExactMatch, "bar")
+	c := NewValue(Content, Contains, "baz")
+
+	m := captainslog.NewSyslogMsg()
+	for _, tc := range []struct {
+		host, program, content           string
+		xor, implies, atLeast2, exactly1 bool
+	}{
+		{"", "", "", false, true, false, false},
+		{"foo", "", "", true, true, false, true},
+		{"foo", "bar", "", false, false, true, false},
+		{"foo", "bar", "baz", true, true, true, false},
+		{"", "bar", "baz", false, true, true, false},
+		{"", "", "baz", true, true, false, true},
+	} {
+		m.Host = tc.host
+		m.SetProgram(tc.program)
+		m.Content = tc.content
+
+		if want, got := tc.xor, NewNAryOp(Xor, a, b, c).Matches(m); want != got {
+			t.Errorf("xor: want != got for %+v, want = %v, got = %v", tc, want, got)
+		}
+		if want, got := tc.implies, NewNAryOp(Implies, a, b, c).Matches(m); want != got {
+			t.Errorf("implies: want != got for %+v, want = %v, got = %v", tc, want, got)
+		}
+		if want, got := tc.atLeast2, NewThresholdOp(AtLeast, 2, a, b, c).Matches(m); want != got {
+			t.Errorf("at_least: want != got for %+v, want = %v, got = %v", tc, want, got)
+		}
+		if want, got := tc.exactly1, NewThresholdOp(Exactly, 1, a, b, c).Matches(m); want != got {
+			t.Errorf("exactly: want != got for %+v, want = %v, got = %v", tc, want, got)
+		}
+	}
+
+	if want, got := true, NewThresholdOp(AtLeast, 0).Matches(m); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	o := NewThresholdOp(AtLeast, 2, a, b)
+	if want, got := `at_least(2, hostname(exact_match, foo), program(exact_match, "bar"))`, o.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := `(hostname(exact_match, foo) xor program(exact_match, "bar"))`, NewNAryOp(Xor, a, b).String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	in := map[string]interface{}{
+		"type":  "exactly",
+		"count": 2,
+		"matchers": []interface{}{
+			map[string]interface{}{"value_matcher": map[string]interface{}{"type": "program", "match_type": "exact_match", "value": "bar"}},
+			map[string]interface{}{"value_matcher": map[string]interface{}{"type": "content", "match_type": "contains", "value": "baz"}},
+		},
+	}
+	decoded := &NAryOp{}
+	if err := decoded.Decode(in); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if want, got := Exactly, decoded.Type; want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := 2, decoded.Count; want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	out := make(map[string]interface{})
+	decoded.Encode(out)
+	if want, got := 2, out["count"]; want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	delete(in, "count")
+	if err := (&NAryOp{}).Decode(in); err == nil {
+		t.Errorf("decoding a threshold op without a count did not return an error")
+	}
+}
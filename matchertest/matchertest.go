@@ -0,0 +1,349 @@
+// Package matchertest runs tests of rules against sample syslog messages.
+//
+// A test suite is a YAML file naming the rules under test and listing sample
+// messages with the expected result:
+//
+//	rules: ../rules
+//	vars:
+//	  region: nyc3
+//	cases:
+//	  - name: staging cron is dropped
+//	    message: "<30>Jan  2 15:04:05 staging-1 cron[12]: job ran"
+//	    rule: team-a/network/drop-cron
+//	    match: true
+//	  - message: "<30>Jan  2 15:04:05 web-1 cron[12]: job ran"
+//	    match: false
+//
+// The rules are a rule file or directory as loaded by matcher.LoadFile and
+// matcher.LoadDir, relative to the suite file. A case without a rule expects
+// the message to be matched, or not, by any of the rules. The optional lists
+// directory resolves list matchers, and vars bind template variables.
+package matchertest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+	matcher "github.com/digitalocean/logmatcher"
+	"github.com/digitalocean/logmatcher/internal/message"
+	"gopkg.in/yaml.v3"
+)
+
+// Case is a sample message and the expected result of matching it.
+type Case struct {
+	Name    string
+	Message string
+	// Rule is the ID of the rule under test, or empty to test the whole set.
+	Rule  string
+	Match bool
+
+	// Line is the line of the case in the suite file.
+	Line int
+}
+
+// Decode decodes a case map into a Case type.
+func (c *Case) Decode(m map[string]interface{}) error {
+	foundMessage := false
+	foundMatch := false
+	for k, v := range m {
+		switch k {
+		case "name", "rule":
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("failed to decode case, %s is not a string", k)
+			}
+			if k == "name" {
+				c.Name = s
+			} else {
+				c.Rule = s
+			}
+		case "message":
+			foundMessage = true
+
+			if s, ok := v.(string); ok {
+				c.Message = s
+			} else {
+				return fmt.Errorf("failed to decode case, message is not a string")
+			}
+		case "match":
+			foundMatch = true
+
+			if b, ok := v.(bool); ok {
+				c.Match = b
+			} else {
+				return fmt.Errorf("failed to decode case, match is not a bool")
+			}
+		default:
+			return fmt.Errorf("failed to decode case, unknown key %q", k)
+		}
+	}
+
+	if !(foundMessage && foundMatch) {
+		return fmt.Errorf("failed to decode case, missing fields")
+	}
+
+	return nil
+}
+
+// String returns the name of the case, or its message if it has none.
+func (c Case) String() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Message
+}
+
+// Suite is a test suite of rules.
+type Suite struct {
+	// Path is the suite file, which the other paths are relative to.
+	Path  string
+	Rules string
+	Lists string
+	Vars  matcher.Vars
+	Cases []Case
+}
+
+// Load reads a suite file.
+func Load(path string) (*Suite, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: failed to decode suite, suite is not a map", path)
+	}
+	root := doc.Content[0]
+
+	s := &Suite{Path: path}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		k, v := root.Content[i], root.Content[i+1]
+		switch k.Value {
+		case "rules", "lists":
+			if v.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("%s:%d: failed to decode suite, %s is not a string", path, v.Line, k.Value)
+			}
+			if k.Value == "rules" {
+				s.Rules = v.Value
+			} else {
+				s.Lists = v.Value
+			}
+		case "vars":
+			vars := make(map[string]interface{})
+			if err := v.Decode(&vars); err != nil {
+				return nil, fmt.Errorf("%s:%d: failed to decode suite, vars is not a map", path, v.Line)
+			}
+			s.Vars = vars
+		case "cases":
+			if v.Kind != yaml.SequenceNode {
+				return nil, fmt.Errorf("%s:%d: failed to decode suite, cases is not a list", path, v.Line)
+			}
+			for _, n := range v.Content {
+				var m map[string]interface{}
+				if err := n.Decode(&m); err != nil {
+					return nil, fmt.Errorf("%s:%d: failed to decode case into map", path, n.Line)
+				}
+				c := Case{Line: n.Line}
+				if err := c.Decode(m); err != nil {
+					return nil, fmt.Errorf("%s:%d: %v", path, n.Line, err)
+				}
+				s.Cases = append(s.Cases, c)
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: failed to decode suite, unknown key %q", path, k.Line, k.Value)
+		}
+	}
+
+	if s.Rules == "" {
+		return nil, fmt.Errorf("%s: failed to decode suite, missing fields", path)
+	}
+
+	return s, nil
+}
+
+// path returns the path p relative to the suite file.
+func (s *Suite) path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(filepath.Dir(s.Path), p)
+}
+
+// LoadRules loads the rules under test, binds their template variables and
+// resolves their lists.
+func (s *Suite) LoadRules() (matcher.Matchers, error) {
+	rules, err := matcher.LoadFile(s.path(s.Rules))
+	if err != nil {
+		return nil, err
+	}
+
+	var lists *matcher.Lists
+	if s.Lists != "" {
+		lists = matcher.NewLists(matcher.NewFileListProvider(s.path(s.Lists)))
+	}
+	for i, r := range rules {
+		if r, err = matcher.Bind(r, s.Vars); err != nil {
+			return nil, err
+		}
+		if lists != nil {
+			if err := lists.Resolve(r); err != nil {
+				return nil, err
+			}
+		}
+		rules[i] = r
+	}
+	return rules, nil
+}
+
+// Result is the outcome of a Case.
+type Result struct {
+	Case Case
+	// Got is whether the message was matched.
+	Got bool
+	// Err is set if the message failed to parse or the rule does not exist.
+	Err error
+	// Trace explains the result, see Trace.
+	Trace string
+}
+
+// Passed returns true if the case ran and the result was the expected one.
+func (r Result) Passed() bool {
+	return r.Err == nil && r.Got == r.Case.Match
+}
+
+// String describes the result, with the trace of a failed case.
+func (r Result) String() string {
+	target := "the rules"
+	if r.Case.Rule != "" {
+		target = fmt.Sprintf("rule %q", r.Case.Rule)
+	}
+
+	switch {
+	case r.Err != nil:
+		return fmt.Sprintf("%s: %v", r.Case, r.Err)
+	case r.Passed():
+		return fmt.Sprintf("%s: ok", r.Case)
+	case r.Case.Match:
+		return fmt.Sprintf("%s: want %s to match, got no match\n%s", r.Case, target, r.Trace)
+	default:
+		return fmt.Sprintf("%s: want %s not to match, got a match\n%s", r.Case, target, r.Trace)
+	}
+}
+
+// Run loads the rules and runs every case of the suite.
+func (s *Suite) Run() ([]Result, error) {
+	rules, err := s.LoadRules()
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]matcher.Matcher)
+	for _, r := range rules {
+		if rule, ok := r.(*matcher.Rule); ok {
+			byID[rule.ID] = rule
+		}
+	}
+
+	results := make([]Result, len(s.Cases))
+	for i, c := range s.Cases {
+		results[i] = run(c, rules, byID)
+	}
+	return results, nil
+}
+
+// run runs a single case.
+func run(c Case, rules matcher.Matchers, byID map[string]matcher.Matcher) Result {
+	r := Result{Case: c}
+
+	msg, err := message.Parse([]byte(c.Message))
+	if err != nil {
+		r.Err = fmt.Errorf("failed to parse message: %v", err)
+		return r
+	}
+
+	if c.Rule != "" {
+		rule, ok := byID[c.Rule]
+		if !ok {
+			r.Err = fmt.Errorf("unknown rule %q", c.Rule)
+			return r
+		}
+		rules = matcher.Matchers{rule}
+	}
+
+	var trace strings.Builder
+	for _, rule := range rules {
+		if rule.Matches(msg) {
+			r.Got = true
+		}
+		trace.WriteString(Trace(rule, msg))
+	}
+	r.Trace = trace.String()
+	return r
+}
+
+// Trace explains the result of matching a message, with a line per node of
+// the matcher tree giving its result. Operators are shown without their
+// children, which follow them indented.
+func Trace(m matcher.Matcher, msg captainslog.SyslogMsg) string {
+	var b strings.Builder
+	matcher.Walk(m, func(node matcher.Matcher, path matcher.Path) bool {
+		result := "no match"
+		if node.Matches(msg) {
+			result = "match"
+		}
+		fmt.Fprintf(&b, "%s%-8s  %s\n", strings.Repeat("  ", len(path)), result, label(node))
+		return true
+	})
+	return b.String()
+}
+
+// label returns the representation of a node in a trace.
+func label(m matcher.Matcher) string {
+	switch o := m.(type) {
+	case *matcher.NAryOp:
+		if o.Type.IsThreshold() {
+			return fmt.Sprintf("%s(%d)", o.Type, o.Count)
+		}
+		return o.Type.String()
+	case *matcher.UnaryOp:
+		return o.Type.String()
+	case *matcher.Rule:
+		if !o.Active() {
+			return fmt.Sprintf("rule(%q), expired %s", o.ID, o.Expires.Format("2006-01-02"))
+		}
+		return fmt.Sprintf("rule(%q)", o.ID)
+	case *matcher.Ref:
+		return o.String()
+	case matcher.Parent:
+		return fmt.Sprintf("%T", m)
+	}
+	return m.String()
+}
+
+// Test runs the suite file as part of a Go test, reporting every failed case
+// as an error.
+func Test(t testing.TB, path string) {
+	t.Helper()
+
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := s.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if !r.Passed() {
+			t.Errorf("%s:%d: %s", s.Path, r.Case.Line, r)
+		}
+	}
+}
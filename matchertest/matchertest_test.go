@@ -0,0 +1,81 @@
+package matchertest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSuite(t *testing.T) {
+	Test(t, "testdata/pass.yaml")
+}
+
+func TestSuiteFailures(t *testing.T) {
+	s, err := Load("testdata/fail.yaml")
+	if err != nil {
+		t.Fatalf("failed to load suite: %v", err)
+	}
+	results, err := s.Run()
+	if err != nil {
+		t.Fatalf("failed to run suite: %v", err)
+	}
+
+	want := []string{
+		`production cron is dropped: want rule "drop-staging-cron" to match, got no match
+no match  rule("drop-staging-cron")
+  no match  and
+    no match  hostname(prefix_match, staging-)
+    match     not
+      no match  content(contains, "error")
+    match     program(exact_match, "cron")
+`,
+		`staging cron errors are dropped: want the rules to match, got no match
+no match  rule("drop-staging-cron")
+  no match  and
+    match     hostname(prefix_match, staging-)
+    no match  not
+      match     content(contains, "error")
+    match     program(exact_match, "cron")
+no match  rule("drop-health-checks")
+  no match  kv("path", exact_match, "/healthz")
+`,
+		`unknown rule: unknown rule "missing"`,
+		`garbage: failed to parse message: Priority not found`,
+	}
+	if len(want) != len(results) {
+		t.Fatalf("want %d results, got %d", len(want), len(results))
+	}
+	for i, r := range results {
+		if r.Passed() {
+			t.Errorf("%s should have failed", r.Case)
+		}
+		if got := r.String(); want[i] != got {
+			t.Errorf("want != got, want =\n%v\ngot =\n%v", want[i], got)
+		}
+	}
+	if want, got := 12, results[2].Case.Line; want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		suite string
+		want  string
+	}{
+		{"cases: []\n", "missing fields"},
+		{"rules: r.yaml\ncases:\n  - message: x\n", ":3: failed to decode case, missing fields"},
+		{"rules: r.yaml\ncases:\n  - message: x\n    match: yes please\n", ":3: failed to decode case, match is not a bool"},
+		{"rules: r.yaml\ncase: []\n", `:2: failed to decode suite, unknown key "case"`},
+	} {
+		path := filepath.Join(t.TempDir(), "suite.yaml")
+		if err := os.WriteFile(path, []byte(tc.suite), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := Load(path)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("want error containing %q, got %v", tc.want, err)
+		}
+	}
+}
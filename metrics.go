@@ -0,0 +1,303 @@
+package matcher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// LatencyBuckets are the upper bounds of the buckets of the evaluation
+// latency histograms of Metrics.
+var LatencyBuckets = []time.Duration{
+	100 * time.Nanosecond,
+	250 * time.Nanosecond,
+	500 * time.Nanosecond,
+	time.Microsecond,
+	2500 * time.Nanosecond,
+	5 * time.Microsecond,
+	10 * time.Microsecond,
+	25 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+}
+
+// Metrics instruments a rule set, recording for every rule and every node of
+// the rules how often it was evaluated, how often it matched and how long it
+// took. The counters are updated atomically, so the rules can be matched from
+// any number of goroutines.
+//
+// The latency of a node includes the latency of its children, and timing
+// every node has a cost of its own, so the latencies are best compared with
+// each other rather than with uninstrumented rules.
+type Metrics struct {
+	rules  Matchers
+	stats  [][]*nodeStats
+	bounds []time.Duration
+}
+
+// NewMetrics returns a new Metrics instrumenting the supplied rules. The rules
+// must be matched through the Metrics, or the Matchers returned by Rules, to
+// be recorded.
+func NewMetrics(rules Matchers) *Metrics {
+	m := &Metrics{
+		stats:  make([][]*nodeStats, len(rules)),
+		bounds: append([]time.Duration(nil), LatencyBuckets...),
+	}
+	for i, r := range rules {
+		m.rules = append(m.rules, instrument(r, Path{}, m.timer(i)))
+	}
+	return m
+}
+
+// nodeStats are the counters of a node.
+type nodeStats struct {
+	path    Path
+	matcher Matcher
+
+	evaluations atomic.Uint64
+	hits        atomic.Uint64
+	// nanos is the total latency of the evaluations.
+	nanos atomic.Int64
+	// buckets counts the evaluations by latency bucket, the last bucket
+	// counting those slower than every bound.
+	buckets []atomic.Uint64
+}
+
+// timed records the evaluations of the matcher it wraps.
+type timed struct {
+	Matcher
+	bounds []time.Duration
+	stats  *nodeStats
+}
+
+// Matches returns true if the wrapped matcher matches the supplied SyslogMsg.
+func (t *timed) Matches(m captainslog.SyslogMsg) bool {
+	matched, _ := t.matchesContent(m, nil)
+	return matched
+}
+
+// matchesContent returns true if the wrapped matcher matches the supplied
+// SyslogMsg, whose logfmt content is parsed by c, along with c.
+func (t *timed) matchesContent(m captainslog.SyslogMsg, c *logfmtContent) (bool, *logfmtContent) {
+	start := time.Now()
+	matched, c := matchContent(t.Matcher, m, c)
+	d := time.Since(start)
+
+	s := t.stats
+	s.evaluations.Add(1)
+	if matched {
+		s.hits.Add(1)
+	}
+	s.nanos.Add(int64(d))
+	i := 0
+	for i < len(t.bounds) && d > t.bounds[i] {
+		i++
+	}
+	s.buckets[i].Add(1)
+	return matched, c
+}
+
+// timer returns the instrument hook wrapping every node of the tree of the
+// i'th rule with a timed matcher.
+func (m *Metrics) timer(i int) func(Matcher, Path) func(Matcher) Matcher {
+	return func(n Matcher, p Path) func(Matcher) Matcher {
+		s := &nodeStats{
+			path:    p,
+			matcher: n,
+			buckets: make([]atomic.Uint64, len(m.bounds)+1),
+		}
+		m.stats[i] = append(m.stats[i], s)
+		return func(n Matcher) Matcher {
+			return &timed{Matcher: n, bounds: m.bounds, stats: s}
+		}
+	}
+}
+
+// Rules returns the instrumented rules.
+func (m *Metrics) Rules() Matchers {
+	return m.rules
+}
+
+// Matches returns true if any of the rules matches the supplied SyslogMsg.
+// Like Matchers, it stops at the first rule which matches.
+func (m *Metrics) Matches(msg captainslog.SyslogMsg) bool {
+	return m.rules.Matches(msg)
+}
+
+// Histogram is a latency histogram.
+type Histogram struct {
+	// Bounds are the upper bounds of the buckets.
+	Bounds []time.Duration
+	// Counts are the numbers of observations of each bucket, with one more
+	// bucket than bounds counting the observations above every bound.
+	Counts []uint64
+	// Sum is the total of the observations.
+	Sum time.Duration
+}
+
+// Count returns the number of observations.
+func (h Histogram) Count() uint64 {
+	var n uint64
+	for _, c := range h.Counts {
+		n += c
+	}
+	return n
+}
+
+// NodeMetrics are the metrics of a node of a rule.
+type NodeMetrics struct {
+	Path        Path
+	Matcher     Matcher
+	Evaluations uint64
+	Hits        uint64
+	Latency     Histogram
+}
+
+// MarshalJSON encodes a NodeMetrics with its path and matcher as strings, and
+// its latencies in nanoseconds.
+func (n NodeMetrics) MarshalJSON() ([]byte, error) {
+	bounds := make([]int64, len(n.Latency.Bounds))
+	for i, b := range n.Latency.Bounds {
+		bounds[i] = int64(b)
+	}
+	return json.Marshal(struct {
+		Path        string   `json:"path"`
+		Matcher     string   `json:"matcher"`
+		Evaluations uint64   `json:"evaluations"`
+		Hits        uint64   `json:"hits"`
+		BoundsNanos []int64  `json:"latency_bounds_ns"`
+		Counts      []uint64 `json:"latency_counts"`
+		SumNanos    int64    `json:"latency_sum_ns"`
+	}{n.Path.String(), n.Matcher.String(), n.Evaluations, n.Hits, bounds, n.Latency.Counts, int64(n.Latency.Sum)})
+}
+
+// RuleMetrics are the metrics of a rule, and of every node of its tree in
+// pre-order, so the first node is the rule itself.
+type RuleMetrics struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	// Nodes are the metrics of the nodes of the rule.
+	Nodes []NodeMetrics `json:"nodes"`
+}
+
+// Name returns the ID of the rule, or its index if it has none.
+func (r RuleMetrics) Name() string {
+	if r.ID != "" {
+		return r.ID
+	}
+	return strconv.Itoa(r.Index)
+}
+
+// MetricsSnapshot is a copy of the metrics of a rule set at a point in time.
+// The counters are read one at a time while rules may be evaluated, so they
+// may be off by the evaluations in progress.
+type MetricsSnapshot struct {
+	Time  time.Time     `json:"time"`
+	Rules []RuleMetrics `json:"rules"`
+}
+
+// Snapshot returns a copy of the current metrics.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	s := MetricsSnapshot{Time: time.Now()}
+	for i, nodes := range m.stats {
+		r := RuleMetrics{Index: i}
+		if rule, ok := nodes[0].matcher.(*Rule); ok {
+			r.ID = rule.ID
+		}
+		for _, n := range nodes {
+			h := Histogram{
+				Bounds: m.bounds,
+				Counts: make([]uint64, len(n.buckets)),
+				Sum:    time.Duration(n.nanos.Load()),
+			}
+			for j := range n.buckets {
+				h.Counts[j] = n.buckets[j].Load()
+			}
+			r.Nodes = append(r.Nodes, NodeMetrics{
+				Path:        n.path,
+				Matcher:     n.matcher,
+				Evaluations: n.evaluations.Load(),
+				Hits:        n.hits.Load(),
+				Latency:     h,
+			})
+		}
+		s.Rules = append(s.Rules, r)
+	}
+	return s
+}
+
+// labelValue escapes a Prometheus label value.
+var labelValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WritePrometheus writes the snapshot in the Prometheus text exposition
+// format. Every node is labeled with the ID, or index, of its rule and its
+// path.
+func (s MetricsSnapshot) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	type labeled struct {
+		labels string
+		node   NodeMetrics
+	}
+	var nodes []labeled
+	for _, r := range s.Rules {
+		for _, n := range r.Nodes {
+			labels := fmt.Sprintf(`rule="%s",path="%s"`, labelValue.Replace(r.Name()), n.Path)
+			nodes = append(nodes, labeled{labels, n})
+		}
+	}
+
+	fmt.Fprintf(bw, "# HELP logmatcher_evaluations_total Number of evaluations of a rule node.\n")
+	fmt.Fprintf(bw, "# TYPE logmatcher_evaluations_total counter\n")
+	for _, n := range nodes {
+		fmt.Fprintf(bw, "logmatcher_evaluations_total{%s} %d\n", n.labels, n.node.Evaluations)
+	}
+
+	fmt.Fprintf(bw, "# HELP logmatcher_hits_total Number of evaluations of a rule node which matched.\n")
+	fmt.Fprintf(bw, "# TYPE logmatcher_hits_total counter\n")
+	for _, n := range nodes {
+		fmt.Fprintf(bw, "logmatcher_hits_total{%s} %d\n", n.labels, n.node.Hits)
+	}
+
+	fmt.Fprintf(bw, "# HELP logmatcher_evaluation_seconds Latency of the evaluations of a rule node.\n")
+	fmt.Fprintf(bw, "# TYPE logmatcher_evaluation_seconds histogram\n")
+	for _, n := range nodes {
+		h := n.node.Latency
+		var cumulative uint64
+		for i, c := range h.Counts {
+			cumulative += c
+			le := "+Inf"
+			if i < len(h.Bounds) {
+				le = strconv.FormatFloat(h.Bounds[i].Seconds(), 'g', -1, 64)
+			}
+			fmt.Fprintf(bw, "logmatcher_evaluation_seconds_bucket{%s,le=\"%s\"} %d\n", n.labels, le, cumulative)
+		}
+		fmt.Fprintf(bw, "logmatcher_evaluation_seconds_sum{%s} %s\n", n.labels, strconv.FormatFloat(h.Sum.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(bw, "logmatcher_evaluation_seconds_count{%s} %d\n", n.labels, cumulative)
+	}
+
+	return bw.Flush()
+}
+
+// ServeHTTP serves a snapshot of the metrics in the Prometheus text
+// exposition format. The snapshot is written out before the response is sent,
+// so a failure is reported as an internal server error.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	if err := m.Snapshot().WritePrometheus(&b); err != nil {
+		http.Error(w, fmt.Sprintf("failed to write metrics, %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(b.Bytes())
+}
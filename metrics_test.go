@@ -0,0 +1,97 @@
+package matcher
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestMetrics(t *testing.T) {
+	rules := Matchers{
+		NewRule("drop-staging-cron", NewNAryOp(And,
+			NewHostname(PrefixMatch, "staging-"),
+			NewValue(Program, ExactMatch, "cron"),
+		)),
+		NewValue(Program, ExactMatch, "nginx"),
+	}
+	m := NewMetrics(rules)
+
+	var msgs []captainslog.SyslogMsg
+	for _, line := range []string{
+		"<30>Jan  2 15:04:05 staging-1 cron[12]: job ran",
+		"<30>Jan  2 15:04:05 web-1 cron[12]: job ran",
+		"<30>Jan  2 15:04:05 web-1 nginx[3]: GET /",
+	} {
+		msg, err := captainslog.NewSyslogMsgFromBytes([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	const workers = 8
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, msg := range msgs {
+				m.Matches(msg)
+			}
+		}()
+	}
+	wg.Wait()
+
+	s := m.Snapshot()
+	if want, got := 2, len(s.Rules); want != got {
+		t.Fatalf("want != got, want = %v, got = %v", want, got)
+	}
+	for _, tc := range []struct {
+		rule, node        int
+		path              string
+		evaluations, hits uint64
+	}{
+		{0, 0, "/", 3 * workers, 1 * workers},
+		{0, 1, "/0", 3 * workers, 1 * workers},
+		{0, 2, "/0/0", 3 * workers, 1 * workers},
+		{0, 3, "/0/1", 1 * workers, 1 * workers},
+		// The second rule is not evaluated once the first one matches.
+		{1, 0, "/", 2 * workers, 1 * workers},
+	} {
+		n := s.Rules[tc.rule].Nodes[tc.node]
+		if want, got := tc.path, n.Path.String(); want != got {
+			t.Errorf("want != got, want = %v, got = %v", want, got)
+		}
+		if want, got := tc.evaluations, n.Evaluations; want != got {
+			t.Errorf("%s %s: want != got, want = %v, got = %v", s.Rules[tc.rule].Name(), n.Path, want, got)
+		}
+		if want, got := tc.hits, n.Hits; want != got {
+			t.Errorf("%s %s: want != got, want = %v, got = %v", s.Rules[tc.rule].Name(), n.Path, want, got)
+		}
+		if want, got := n.Evaluations, n.Latency.Count(); want != got {
+			t.Errorf("%s %s: want != got, want = %v, got = %v", s.Rules[tc.rule].Name(), n.Path, want, got)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if want, got := "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE logmatcher_evaluations_total counter\n",
+		`logmatcher_evaluations_total{rule="drop-staging-cron",path="/0/1"} 8` + "\n",
+		`logmatcher_hits_total{rule="1",path="/"} 8` + "\n",
+		"# TYPE logmatcher_evaluation_seconds histogram\n",
+		`logmatcher_evaluation_seconds_bucket{rule="1",path="/",le="+Inf"} 16` + "\n",
+		`logmatcher_evaluation_seconds_count{rule="1",path="/"} 16` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want output containing %q, got:\n%s", want, out)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package matcher
 import (
 	"bytes"
 	"fmt"
+	"reflect"
 
 	"github.com/digitalocean/captainslog"
 )
@@ -10,10 +11,20 @@ import (
 // NAryOpType is the enum class for representing n-ary operation types.
 type NAryOpType int
 
-// N-ary operation types.
+// N-ary operation types. New NAryOpType values MUST be appended to the end of
+// the list for database consistency.
 const (
 	And NAryOpType = iota
 	Or
+	// Xor matches if an odd number of its matchers match.
+	Xor
+	// Implies matches unless all of its matchers but the last match and the
+	// last does not, i.e. (a implies b implies c) is (a and b) implies c.
+	Implies
+	// AtLeast matches if at least Count of its matchers match.
+	AtLeast
+	// Exactly matches if exactly Count of its matchers match.
+	Exactly
 )
 
 // String converts a NAryOpType to its corresponding string representation.
@@ -23,6 +34,14 @@ func (o NAryOpType) String() string {
 		return "and"
 	case Or:
 		return "or"
+	case Xor:
+		return "xor"
+	case Implies:
+		return "implies"
+	case AtLeast:
+		return "at_least"
+	case Exactly:
+		return "exactly"
 	default:
 		return "invalid type"
 	}
@@ -36,6 +55,14 @@ func (o *NAryOpType) FromString(s string) error {
 		*o = And
 	case "or":
 		*o = Or
+	case "xor":
+		*o = Xor
+	case "implies":
+		*o = Implies
+	case "at_least":
+		*o = AtLeast
+	case "exactly":
+		*o = Exactly
 	default:
 		return fmt.Errorf("failed to convert string to NAryOpType")
 	}
@@ -43,11 +70,18 @@ func (o *NAryOpType) FromString(s string) error {
 	return nil
 }
 
+// IsThreshold returns true if the NAryOpType takes a count.
+func (o NAryOpType) IsThreshold() bool {
+	return o == AtLeast || o == Exactly
+}
+
 // NAryOp encapsulates a type of n-ary operation and the slice of abstract
 // Matchers on which it applies.
 type NAryOp struct {
 	Type     NAryOpType
 	Matchers Matchers
+	// Count is the threshold of the AtLeast and Exactly operations.
+	Count int
 }
 
 // NewNAryOp returns a new NAryOp with the specified operation type and
@@ -59,9 +93,29 @@ func NewNAryOp(t NAryOpType, v ...Matcher) *NAryOp {
 	}
 }
 
+// NewThresholdOp returns a new NAryOp with the specified threshold operation
+// type (AtLeast or Exactly), count and set of exclusions.
+func NewThresholdOp(t NAryOpType, n int, v ...Matcher) *NAryOp {
+	return &NAryOp{
+		Type:     t,
+		Matchers: v,
+		Count:    n,
+	}
+}
+
 // String converts an NAryOp to its corresponding string representation.
 func (o NAryOp) String() string {
 	var b bytes.Buffer
+	if o.Type.IsThreshold() {
+		fmt.Fprintf(&b, "%s(%d", o.Type, o.Count)
+		for _, m := range o.Matchers {
+			b.WriteString(", ")
+			b.WriteString(m.String())
+		}
+		b.WriteByte(')')
+		return b.String()
+	}
+
 	b.WriteByte('(')
 	for i, m := range o.Matchers {
 		if i != 0 {
@@ -79,23 +133,75 @@ func (o NAryOp) String() string {
 
 // Matches returns true if the NAryOp matches the supplied SyslogMsg.
 func (o *NAryOp) Matches(m captainslog.SyslogMsg) bool {
+	matched, _ := o.matchesContent(m, nil)
+	return matched
+}
+
+// matchesContent returns true if the NAryOp matches the supplied SyslogMsg,
+// whose logfmt content is parsed by c, along with c.
+func (o *NAryOp) matchesContent(m captainslog.SyslogMsg, c *logfmtContent) (bool, *logfmtContent) {
+	var matched bool
 	switch o.Type {
 	case And:
 		for _, v := range o.Matchers {
-			if !v.Matches(m) {
-				return false
+			if matched, c = matchContent(v, m, c); !matched {
+				return false, c
 			}
 		}
-		return true
+		return true, c
 	case Or:
 		for _, v := range o.Matchers {
-			if v.Matches(m) {
-				return true
+			if matched, c = matchContent(v, m, c); matched {
+				return true, c
+			}
+		}
+		return false, c
+	case Xor:
+		odd := false
+		for _, v := range o.Matchers {
+			if matched, c = matchContent(v, m, c); matched {
+				odd = !odd
 			}
 		}
-		return false
+		return odd, c
+	case Implies:
+		if len(o.Matchers) == 0 {
+			return true, c
+		}
+		last := len(o.Matchers) - 1
+		for _, v := range o.Matchers[:last] {
+			if matched, c = matchContent(v, m, c); !matched {
+				return true, c
+			}
+		}
+		return matchContent(o.Matchers[last], m, c)
+	case AtLeast:
+		n := 0
+		for i, v := range o.Matchers {
+			if n >= o.Count {
+				return true, c
+			}
+			if n+len(o.Matchers)-i < o.Count {
+				return false, c
+			}
+			if matched, c = matchContent(v, m, c); matched {
+				n++
+			}
+		}
+		return n >= o.Count, c
+	case Exactly:
+		n := 0
+		for i, v := range o.Matchers {
+			if n > o.Count || n+len(o.Matchers)-i < o.Count {
+				return false, c
+			}
+			if matched, c = matchContent(v, m, c); matched {
+				n++
+			}
+		}
+		return n == o.Count, c
 	default:
-		return false
+		return false, c
 	}
 }
 
@@ -103,6 +209,7 @@ func (o *NAryOp) Matches(m captainslog.SyslogMsg) bool {
 func (o *NAryOp) Decode(m map[string]interface{}) error {
 	foundType := false
 	foundMatchers := false
+	foundCount := false
 	for k, v := range m {
 		switch k {
 		case "type":
@@ -127,10 +234,31 @@ func (o *NAryOp) Decode(m map[string]interface{}) error {
 			} else {
 				return fmt.Errorf("failed to decode n-ary op, matchers is not a slice")
 			}
+		case "count":
+			if v == nil {
+				continue
+			}
+			foundCount = true
+
+			val := reflect.ValueOf(v)
+			switch val.Kind() {
+			case reflect.Int, reflect.Int64:
+				o.Count = int(val.Int())
+			case reflect.Float64:
+				if val.Float() != float64(int(val.Float())) {
+					return fmt.Errorf("failed to decode n-ary op, count is not an integer")
+				}
+				o.Count = int(val.Float())
+			default:
+				return fmt.Errorf("failed to decode n-ary op, count is not an integer")
+			}
+			if o.Count < 0 {
+				return fmt.Errorf("failed to decode n-ary op, count is negative")
+			}
 		}
 	}
 
-	if !(foundType && foundMatchers) {
+	if !(foundType && foundMatchers) || (o.Type.IsThreshold() && !foundCount) {
 		return fmt.Errorf("failed to decode n-ary op, missing fields")
 	}
 
@@ -147,4 +275,7 @@ func (o *NAryOp) Encode(out map[string]interface{}) {
 		v = append(v, x)
 	}
 	out["matchers"] = v
+	if o.Type.IsThreshold() {
+		out["count"] = o.Count
+	}
 }
@@ -0,0 +1,222 @@
+package matcher
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// defaultBatchSize is the number of messages of a shard when the BatchSize of
+// a ParallelMatcher is not set.
+const defaultBatchSize = 256
+
+// ParallelMatcher evaluates a rule set on a pool of worker goroutines. The
+// messages are sharded into batches, each batch evaluated by one worker with
+// Matchers.MatchBatch, and the results are returned in input order.
+//
+// The rules are shared by the workers, so they must be safe for concurrent
+// use, which every matcher of this package is except the rules of a Coverage.
+type ParallelMatcher struct {
+	Rules Matchers
+	// Workers is the number of worker goroutines, GOMAXPROCS if 0.
+	Workers int
+	// BatchSize is the number of messages evaluated by a worker at a time,
+	// rounded up to a multiple of 64. It defaults to 256.
+	BatchSize int
+}
+
+// NewParallelMatcher returns a new ParallelMatcher evaluating the supplied
+// rules on the specified number of workers.
+func NewParallelMatcher(rules Matchers, workers int) *ParallelMatcher {
+	return &ParallelMatcher{
+		Rules:   rules,
+		Workers: workers,
+	}
+}
+
+// workers returns the number of workers.
+func (p *ParallelMatcher) workers() int {
+	if p.Workers > 0 {
+		return p.Workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// batchSize returns the number of messages of a shard, a multiple of 64 so
+// shards never share a word of a Bitset.
+func (p *ParallelMatcher) batchSize() int {
+	n := p.BatchSize
+	if n <= 0 {
+		n = defaultBatchSize
+	}
+	return (n + 63) / 64 * 64
+}
+
+// MatchBatch returns the set of the indexes of the messages matched by any of
+// the rules, evaluating shards of the messages in parallel. It returns the
+// error of the context if it is canceled before every shard was evaluated.
+func (p *ParallelMatcher) MatchBatch(ctx context.Context, msgs []captainslog.SyslogMsg) (Bitset, error) {
+	out := NewBitset(len(msgs))
+	size := p.batchSize()
+
+	starts := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range starts {
+				end := start + size
+				if end > len(msgs) {
+					end = len(msgs)
+				}
+				matched := p.Rules.MatchBatch(msgs[start:end])
+				copy(out.words[start/64:], matched.words)
+			}
+		}()
+	}
+
+	var err error
+	for start := 0; start < len(msgs) && err == nil; start += size {
+		select {
+		case starts <- start:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	close(starts)
+	wg.Wait()
+
+	if err != nil {
+		return Bitset{}, err
+	}
+	return out, nil
+}
+
+// ParallelResult is the result of the evaluation of a message by a ParallelMatcher.
+type ParallelResult struct {
+	Msg     captainslog.SyslogMsg
+	Matched bool
+}
+
+// job is a batch of messages evaluated by a worker of Run.
+type job struct {
+	msgs    []captainslog.SyslogMsg
+	matched Bitset
+	done    chan struct{}
+}
+
+// Run evaluates the messages received from in until it is closed, and sends
+// the result of every message to out in the order the messages were
+// received. Messages are batched as they arrive, so a slow input does not
+// delay the results of the messages already received.
+//
+// Run closes out when it returns. It returns the error of the context if it
+// is canceled, in which case the results of the messages in flight are
+// dropped.
+func (p *ParallelMatcher) Run(ctx context.Context, in <-chan captainslog.SyslogMsg, out chan<- ParallelResult) error {
+	defer close(out)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := p.workers()
+	jobs := make(chan *job)
+	// pending holds the jobs in input order, bounding the number of batches
+	// in flight.
+	pending := make(chan *job, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				j.matched = p.Rules.MatchBatch(j.msgs)
+				close(j.done)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(pending)
+		defer close(jobs)
+
+		for {
+			msgs, more := p.collect(runCtx, in)
+			if len(msgs) > 0 {
+				j := &job{msgs: msgs, done: make(chan struct{})}
+				select {
+				case pending <- j:
+				case <-runCtx.Done():
+					return
+				}
+				select {
+				case jobs <- j:
+				case <-runCtx.Done():
+					return
+				}
+			}
+			if !more {
+				return
+			}
+		}
+	}()
+
+	p.emit(runCtx, pending, out)
+	cancel()
+	wg.Wait()
+	return ctx.Err()
+}
+
+// collect receives a batch of messages, waiting for the first one only. It
+// returns false once in is closed or the context canceled.
+func (p *ParallelMatcher) collect(ctx context.Context, in <-chan captainslog.SyslogMsg) ([]captainslog.SyslogMsg, bool) {
+	var msgs []captainslog.SyslogMsg
+	select {
+	case msg, ok := <-in:
+		if !ok {
+			return nil, false
+		}
+		msgs = append(msgs, msg)
+	case <-ctx.Done():
+		return nil, false
+	}
+
+	size := p.batchSize()
+	for len(msgs) < size {
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				return msgs, false
+			}
+			msgs = append(msgs, msg)
+		default:
+			return msgs, true
+		}
+	}
+	return msgs, true
+}
+
+// emit sends the results of the pending jobs to out in order, until pending
+// is closed or the context canceled.
+func (p *ParallelMatcher) emit(ctx context.Context, pending <-chan *job, out chan<- ParallelResult) {
+	for j := range pending {
+		select {
+		case <-j.done:
+		case <-ctx.Done():
+			return
+		}
+		for i, msg := range j.msgs {
+			select {
+			case out <- ParallelResult{Msg: msg, Matched: j.matched.Test(i)}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
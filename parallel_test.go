@@ -0,0 +1,187 @@
+package matcher
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// largeRuleSet returns n rules, most of which match none of the messages of
+// batchMessages, so every message is evaluated by most rules.
+func largeRuleSet(n int) Matchers {
+	rules := make(Matchers, 0, n)
+	for i := 0; i < n-2; i++ {
+		rules = append(rules, NewRule(fmt.Sprintf("rule-%d", i), NewNAryOp(And,
+			NewHostname(PrefixMatch, fmt.Sprintf("host-%d-", i)),
+			NewValue(Program, ExactMatch, "nginx"),
+			NewKV("status", GreaterThanEqual, 500.0),
+		)))
+	}
+	return append(rules,
+		NewNAryOp(And, NewHostname(PrefixMatch, "web-"), NewValue(Program, ExactMatch, "cron")),
+		NewKV("status", GreaterThanEqual, 500.0),
+	)
+}
+
+// serialMatches returns whether any of the rules matches each message.
+func serialMatches(rules Matchers, msgs []captainslog.SyslogMsg) []bool {
+	out := make([]bool, len(msgs))
+	for i, msg := range msgs {
+		for _, r := range rules {
+			if r.Matches(msg) {
+				out[i] = true
+				break
+			}
+		}
+	}
+	return out
+}
+
+func TestParallelMatcherMatchBatch(t *testing.T) {
+	rules := largeRuleSet(50)
+	for _, n := range []int{0, 1, 64, 1000} {
+		msgs := batchMessages(t, n)
+		want := serialMatches(rules, msgs)
+
+		for _, p := range []*ParallelMatcher{
+			NewParallelMatcher(rules, 0),
+			{Rules: rules, Workers: 3, BatchSize: 100},
+		} {
+			got, err := p.MatchBatch(context.Background(), msgs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range msgs {
+				if want[i] != got.Test(i) {
+					t.Errorf("%d messages: message %d: want != got, want = %v, got = %v", n, i, want[i], got.Test(i))
+				}
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewParallelMatcher(rules, 2).MatchBatch(ctx, batchMessages(t, 1000)); err != context.Canceled {
+		t.Errorf("want != got, want = %v, got = %v", context.Canceled, err)
+	}
+}
+
+func TestParallelMatcherRun(t *testing.T) {
+	rules := largeRuleSet(50)
+	msgs := batchMessages(t, 1000)
+	want := serialMatches(rules, msgs)
+
+	p := &ParallelMatcher{Rules: rules, Workers: 4, BatchSize: 64}
+	in := make(chan captainslog.SyslogMsg)
+	out := make(chan ParallelResult)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p.Run(context.Background(), in, out)
+	}()
+	go func() {
+		for _, msg := range msgs {
+			in <- msg
+		}
+		close(in)
+	}()
+
+	i := 0
+	for r := range out {
+		if want, got := msgs[i].Tag.Pid, r.Msg.Tag.Pid; want != got {
+			t.Fatalf("result %d: want != got, want = %v, got = %v", i, want, got)
+		}
+		if want[i] != r.Matched {
+			t.Errorf("result %d: want != got, want = %v, got = %v", i, want[i], r.Matched)
+		}
+		i++
+	}
+	if want, got := len(msgs), i; want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("want nil error, got %v", err)
+	}
+}
+
+func TestParallelMatcherRunCancel(t *testing.T) {
+	p := NewParallelMatcher(largeRuleSet(10), 2)
+	msgs := batchMessages(t, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan captainslog.SyslogMsg, len(msgs))
+	for _, msg := range msgs {
+		in <- msg
+	}
+	// in is never closed, and the first result is never received.
+	out := make(chan ParallelResult)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p.Run(ctx, in, out)
+	}()
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("want != got, want = %v, got = %v", context.Canceled, err)
+	}
+	for range out {
+		// Results sent before the cancellation was seen may be received.
+	}
+}
+
+func BenchmarkMatchSerial(b *testing.B) {
+	rules := largeRuleSet(500)
+	msgs := batchMessages(b, 4096)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		serialMatches(rules, msgs)
+	}
+	b.ReportMetric(float64(b.N*len(msgs))/time.Since(start).Seconds(), "msgs/s")
+}
+
+func BenchmarkMatchBatchSerial(b *testing.B) {
+	rules := largeRuleSet(500)
+	msgs := batchMessages(b, 4096)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		rules.MatchBatch(msgs)
+	}
+	b.ReportMetric(float64(b.N*len(msgs))/time.Since(start).Seconds(), "msgs/s")
+}
+
+func BenchmarkParallelMatchBatch(b *testing.B) {
+	p := NewParallelMatcher(largeRuleSet(500), 0)
+	msgs := batchMessages(b, 4096)
+	ctx := context.Background()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.MatchBatch(ctx, msgs); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N*len(msgs))/time.Since(start).Seconds(), "msgs/s")
+}
+
+func BenchmarkParallelRun(b *testing.B) {
+	p := NewParallelMatcher(largeRuleSet(500), 0)
+	msgs := batchMessages(b, 4096)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		in := make(chan captainslog.SyslogMsg, len(msgs))
+		for _, msg := range msgs {
+			in <- msg
+		}
+		close(in)
+		out := make(chan ParallelResult, len(msgs))
+		if err := p.Run(context.Background(), in, out); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N*len(msgs))/time.Since(start).Seconds(), "msgs/s")
+}
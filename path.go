@@ -0,0 +1,35 @@
+package matcher
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Path is the position of a node in a matcher tree, given as the index of
+// each child on the way from the root. The root has an empty path, and the
+// matcher of a UnaryOp is its child 0.
+type Path []int
+
+// String converts a Path to its corresponding string representation, e.g. "/"
+// for the root and "/1/0" for the first child of the second child of the
+// root.
+func (p Path) String() string {
+	if len(p) == 0 {
+		return "/"
+	}
+
+	var b strings.Builder
+	for _, i := range p {
+		b.WriteByte('/')
+		b.WriteString(strconv.Itoa(i))
+	}
+	return b.String()
+}
+
+// Child returns the path of the i'th child of the node at this path.
+func (p Path) Child(i int) Path {
+	c := make(Path, len(p)+1)
+	copy(c, p)
+	c[len(p)] = i
+	return c
+}
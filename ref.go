@@ -0,0 +1,103 @@
+package matcher
+
+import (
+	"fmt"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// Ref represents a reference to a named definition of a rule Document. The
+// referenced matcher is set when the Document is decoded.
+type Ref struct {
+	Name    string
+	Matcher Matcher
+}
+
+// NewRef returns a new Ref to the named definition, which is the supplied
+// matcher.
+func NewRef(n string, m Matcher) *Ref {
+	return &Ref{
+		Name:    n,
+		Matcher: m,
+	}
+}
+
+// String converts a Ref to its corresponding string representation. Use
+// Expand to print the referenced matcher instead.
+func (r Ref) String() string {
+	return fmt.Sprintf("ref(%q)", r.Name)
+}
+
+// Matches returns true if the referenced matcher matches the supplied
+// SyslogMsg. An unresolved Ref never matches.
+func (r *Ref) Matches(m captainslog.SyslogMsg) bool {
+	matched, _ := r.matchesContent(m, nil)
+	return matched
+}
+
+// matchesContent returns true if the referenced matcher matches the supplied
+// SyslogMsg, whose logfmt content is parsed by c, along with c.
+func (r *Ref) matchesContent(m captainslog.SyslogMsg, c *logfmtContent) (bool, *logfmtContent) {
+	if r.Matcher == nil {
+		return false, c
+	}
+	return matchContent(r.Matcher, m, c)
+}
+
+// Children returns the referenced matcher, if the Ref is resolved.
+func (r *Ref) Children() Matchers {
+	if r.Matcher == nil {
+		return nil
+	}
+	return Matchers{r.Matcher}
+}
+
+// WithChildren returns a copy of the Ref referencing the supplied matcher.
+func (r *Ref) WithChildren(cs Matchers) Matcher {
+	if len(cs) == 0 {
+		return NewRef(r.Name, nil)
+	}
+	return NewRef(r.Name, cs[0])
+}
+
+// Decode decodes a matcher map into a Ref type. The Ref is resolved by
+// Document.Decode.
+func (r *Ref) Decode(m map[string]interface{}) error {
+	foundName := false
+	for k, v := range m {
+		switch k {
+		case "name":
+			foundName = true
+
+			if n, ok := v.(string); ok {
+				r.Name = n
+			} else {
+				return fmt.Errorf("failed to decode ref matcher, name is not a string")
+			}
+		}
+	}
+
+	if !foundName {
+		return fmt.Errorf("failed to decode ref matcher, missing fields")
+	}
+
+	return nil
+}
+
+// Encode encodes a Ref into a matcher map. Only the name is encoded, not the
+// referenced matcher.
+func (r *Ref) Encode(out map[string]interface{}) {
+	out["name"] = r.Name
+}
+
+// Expand returns a copy of the matcher tree in which every resolved Ref is
+// replaced by the matcher it references, e.g. to print or analyze the fully
+// expanded tree.
+func Expand(m Matcher) Matcher {
+	return Transform(m, func(n Matcher) Matcher {
+		if r, ok := n.(*Ref); ok && r.Matcher != nil {
+			return r.Matcher
+		}
+		return n
+	})
+}
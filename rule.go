@@ -0,0 +1,201 @@
+package matcher
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// Rule wraps a Matcher with the metadata identifying and describing it. A
+// Rule is itself a Matcher, which stops matching once it expires.
+type Rule struct {
+	ID          string
+	Name        string
+	Description string
+	Owner       string
+	Labels      map[string]string
+	Created     time.Time
+	// Expires is the time after which the Rule is inactive. The zero value
+	// never expires.
+	Expires time.Time
+	Matcher Matcher
+}
+
+// NewRule returns a new Rule with the specified ID wrapping the matcher.
+func NewRule(id string, m Matcher) *Rule {
+	return &Rule{
+		ID:      id,
+		Matcher: m,
+	}
+}
+
+// String converts a Rule to its corresponding string representation.
+func (r Rule) String() string {
+	return fmt.Sprintf("rule(%q, %s)", r.ID, r.Matcher)
+}
+
+// ActiveAt returns true if the Rule has not expired at the supplied time.
+func (r *Rule) ActiveAt(t time.Time) bool {
+	return r.Expires.IsZero() || t.Before(r.Expires)
+}
+
+// Active returns true if the Rule has not expired. The clock is only read
+// for a Rule which expires.
+func (r *Rule) Active() bool {
+	return r.Expires.IsZero() || r.ActiveAt(time.Now())
+}
+
+// Matches returns true if the Rule is active and its matcher matches the
+// supplied SyslogMsg.
+func (r *Rule) Matches(m captainslog.SyslogMsg) bool {
+	matched, _ := r.matchesContent(m, nil)
+	return matched
+}
+
+// matchesContent returns true if the Rule is active and its matcher matches
+// the supplied SyslogMsg, whose logfmt content is parsed by c, along with c.
+func (r *Rule) matchesContent(m captainslog.SyslogMsg, c *logfmtContent) (bool, *logfmtContent) {
+	if !r.Active() {
+		return false, c
+	}
+	return matchContent(r.Matcher, m, c)
+}
+
+// Children returns the matcher of the Rule.
+func (r *Rule) Children() Matchers {
+	return Matchers{r.Matcher}
+}
+
+// WithChildren returns a copy of the Rule wrapping the supplied matcher.
+func (r *Rule) WithChildren(cs Matchers) Matcher {
+	c := *r
+	c.Matcher = cs[0]
+	return &c
+}
+
+// decodeTime decodes a timestamp, given as an RFC 3339 string, a date, or a
+// time.Time as produced by YAML decoders.
+func decodeTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+			if p, err := time.Parse(layout, t); err == nil {
+				return p, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// Decode decodes a rule map into a Rule type.
+func (r *Rule) Decode(m map[string]interface{}) error {
+	foundID := false
+	foundMatcher := false
+	for k, v := range m {
+		switch k {
+		case "id":
+			foundID = true
+
+			if id, ok := v.(string); ok {
+				r.ID = id
+			} else {
+				return fmt.Errorf("failed to decode rule, id is not a string")
+			}
+		case "name", "description", "owner":
+			s, ok := v.(string)
+			if !ok {
+				if v == nil {
+					continue
+				}
+				return fmt.Errorf("failed to decode rule, %s is not a string", k)
+			}
+			switch k {
+			case "name":
+				r.Name = s
+			case "description":
+				r.Description = s
+			case "owner":
+				r.Owner = s
+			}
+		case "labels":
+			labels, ok := v.(map[string]interface{})
+			if !ok {
+				if v == nil {
+					continue
+				}
+				return fmt.Errorf("failed to decode rule, labels is not a map")
+			}
+			r.Labels = make(map[string]string, len(labels))
+			for lk, lv := range labels {
+				s, ok := lv.(string)
+				if !ok {
+					return fmt.Errorf("failed to decode rule, label %q is not a string", lk)
+				}
+				r.Labels[lk] = s
+			}
+		case "created", "expires":
+			if v == nil {
+				continue
+			}
+			t, ok := decodeTime(v)
+			if !ok {
+				return fmt.Errorf("failed to decode rule, %s is not a timestamp", k)
+			}
+			if k == "created" {
+				r.Created = t
+			} else {
+				r.Expires = t
+			}
+		case "matcher":
+			foundMatcher = true
+
+			if matcher, ok := v.(map[string]interface{}); ok {
+				val, err := Decode(matcher)
+				if err != nil {
+					return err
+				}
+				r.Matcher = val
+			} else {
+				return fmt.Errorf("failed to decode rule, matcher is not a map")
+			}
+		}
+	}
+
+	if !(foundID && foundMatcher) {
+		return fmt.Errorf("failed to decode rule, missing fields")
+	}
+
+	return nil
+}
+
+// Encode encodes a Rule into a rule map. Empty metadata is omitted.
+func (r *Rule) Encode(out map[string]interface{}) {
+	out["id"] = r.ID
+	if r.Name != "" {
+		out["name"] = r.Name
+	}
+	if r.Description != "" {
+		out["description"] = r.Description
+	}
+	if r.Owner != "" {
+		out["owner"] = r.Owner
+	}
+	if len(r.Labels) > 0 {
+		labels := make(map[string]interface{}, len(r.Labels))
+		for k, v := range r.Labels {
+			labels[k] = v
+		}
+		out["labels"] = labels
+	}
+	if !r.Created.IsZero() {
+		out["created"] = r.Created.Format(time.RFC3339)
+	}
+	if !r.Expires.IsZero() {
+		out["expires"] = r.Expires.Format(time.RFC3339)
+	}
+	out["matcher"] = make(map[string]interface{})
+	Encode(r.Matcher, out["matcher"].(map[string]interface{}))
+}
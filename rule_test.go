@@ -0,0 +1,122 @@
+package matcher
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+	"gopkg.in/yaml.v3"
+)
+
+const ruleYAML = `
+rules:
+  - rule:
+      id: drop-staging-cron
+      name: Drop staging cron
+      description: Cron on staging hosts is noisy, see OPS-1234.
+      owner: sre@example.com
+      labels:
+        team: sre
+      created: 2024-01-10T09:00:00Z
+      expires: 2024-07-01
+      matcher:
+        n_ary_op:
+          type: and
+          matchers:
+            - hostname_matcher:
+                match_type: prefix_match
+                hostname: staging-
+            - value_matcher:
+                type: program
+                match_type: exact_match
+                value: cron
+`
+
+func TestRule(t *testing.T) {
+	in := make(map[string]interface{})
+	if err := yaml.Unmarshal([]byte(ruleYAML), &in); err != nil {
+		t.Fatalf("failed to unmarshal document: %v", err)
+	}
+	d := &Document{}
+	if err := d.Decode(in); err != nil {
+		t.Fatalf("failed to decode document: %v", err)
+	}
+
+	r, ok := d.Rules[0].(*Rule)
+	if !ok {
+		t.Fatalf("want *Rule, got %T", d.Rules[0])
+	}
+	want := &Rule{
+		ID:          "drop-staging-cron",
+		Name:        "Drop staging cron",
+		Description: "Cron on staging hosts is noisy, see OPS-1234.",
+		Owner:       "sre@example.com",
+		Labels:      map[string]string{"team": "sre"},
+		Created:     time.Date(2024, 1, 10, 9, 0, 0, 0, time.UTC),
+		Expires:     time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+		Matcher:     NewNAryOp(And, NewHostname(PrefixMatch, "staging-"), NewValue(Program, ExactMatch, "cron")),
+	}
+	if !reflect.DeepEqual(want, r) {
+		t.Errorf("want != got, want = %#v, got = %#v", want, r)
+	}
+	if want, got := `rule("drop-staging-cron", (hostname(prefix_match, staging-) and program(exact_match, "cron")))`, r.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	// The metadata survives a round trip through JSON.
+	out := make(map[string]interface{})
+	Encode(r, out)
+	b, err := json.Marshal(out)
+	if err != nil {
+		t.Fatalf("failed to marshal rule: %v", err)
+	}
+	in = make(map[string]interface{})
+	if err := json.Unmarshal(b, &in); err != nil {
+		t.Fatalf("failed to unmarshal rule: %v", err)
+	}
+	decoded, err := Decode(in)
+	if err != nil {
+		t.Fatalf("failed to decode encoded rule: %v", err)
+	}
+	if !reflect.DeepEqual(want, decoded) {
+		t.Errorf("want != got, want = %#v, got = %#v", want, decoded)
+	}
+}
+
+func TestRuleExpiry(t *testing.T) {
+	m := captainslog.NewSyslogMsg()
+	m.Host = "staging-1"
+
+	r := NewRule("staging", NewHostname(PrefixMatch, "staging-"))
+	if !r.Active() || !r.Matches(m) {
+		t.Errorf("rule without expiry should be active")
+	}
+
+	r.Expires = time.Now().Add(time.Hour)
+	if !r.Matches(m) {
+		t.Errorf("rule should match before it expires")
+	}
+	if r.ActiveAt(r.Expires) || r.ActiveAt(r.Expires.Add(time.Second)) {
+		t.Errorf("rule should be inactive once it expires")
+	}
+
+	r.Expires = time.Now().Add(-time.Hour)
+	if r.Active() || r.Matches(m) {
+		t.Errorf("expired rule should not match")
+	}
+}
+
+func TestRuleDecodeErrors(t *testing.T) {
+	for _, in := range []map[string]interface{}{
+		{"id": "a"},
+		{"matcher": map[string]interface{}{"constant_matcher": map[string]interface{}{"value": true}}},
+		{"id": "a", "expires": "next week", "matcher": map[string]interface{}{"constant_matcher": map[string]interface{}{"value": true}}},
+		{"id": "a", "labels": map[string]interface{}{"n": 1}, "matcher": map[string]interface{}{"constant_matcher": map[string]interface{}{"value": true}}},
+	} {
+		if err := (&Rule{}).Decode(in); err == nil {
+			t.Errorf("%v should not decode", in)
+		}
+	}
+}
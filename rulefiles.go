@@ -0,0 +1,314 @@
+package matcher
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadFile reads the rules of a YAML or JSON rule file, and of the files it
+// includes. See LoadDir for the file format. Rule IDs are only prefixed with
+// a namespace the file declares.
+func LoadFile(path string) (Matchers, error) {
+	rules, _, err := loadRules(path)
+	return rules, err
+}
+
+// LoadDir reads the rules of every .yaml, .yml and .json file in a directory
+// tree, skipping files and directories whose names start with a dot.
+//
+// A rule file holds either a list of matchers, or a map with the keys:
+//
+//   - rules: the list of matchers.
+//   - definitions: named matchers, which the rules of every file can
+//     reference with a ref_matcher.
+//   - include: a file, or a list of files, to load as well, relative to the
+//     including file. Glob patterns are expanded. Every file is loaded once.
+//   - namespace: the prefix of the IDs of the rules of the file.
+//
+// The ID of a Rule is prefixed with the namespace of its file, which defaults
+// to the path of the file relative to the directory, without extension. A
+// rule "drop-cron" of the file team-a/network.yaml has the ID
+// "team-a/network/drop-cron". Rule IDs and definition names must be unique
+// across all files. Errors are reported with the file name and line number.
+func LoadDir(dir string) (Matchers, error) {
+	rules, _, err := loadRules(dir)
+	return rules, err
+}
+
+// loadRules loads the rule file or directory tree at path, and returns the
+// rules and the paths of every file read.
+func loadRules(path string) (Matchers, []string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l := &ruleLoader{
+		seen:   make(map[string]bool),
+		defs:   make(map[string]Matcher),
+		defPos: make(map[string]string),
+		ids:    make(map[string]string),
+	}
+
+	if !fi.IsDir() {
+		if err := l.file(path, ""); err != nil {
+			return nil, l.files, err
+		}
+		return l.rules, l.files, l.resolve()
+	}
+
+	l.root = path
+	files, err := ruleFiles(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range files {
+		if err := l.file(f, ""); err != nil {
+			return nil, l.files, err
+		}
+	}
+	return l.rules, l.files, l.resolve()
+}
+
+// ruleFiles returns the .yaml, .yml and .json files of a directory tree in
+// lexical order, skipping files and directories whose names start with a dot.
+func ruleFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && isRuleFile(p) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// ruleLoader accumulates the rules and definitions of rule files.
+type ruleLoader struct {
+	// root is the directory namespaces are relative to, empty when loading
+	// a single file.
+	root string
+
+	seen  map[string]bool
+	files []string
+	rules Matchers
+	// rulePos are the positions of the rules.
+	rulePos []string
+
+	defs   map[string]Matcher
+	defPos map[string]string
+	// ids maps the IDs of the rules to their positions.
+	ids map[string]string
+}
+
+// pos returns the position of a node in a file.
+func pos(path string, n *yaml.Node) string {
+	return fmt.Sprintf("%s:%d", path, n.Line)
+}
+
+// resolve resolves the refs of the loaded rules, reporting errors at the
+// position of the rule or definition holding the ref.
+func (l *ruleLoader) resolve() error {
+	return NewDocument(l.defs, l.rules...).resolve(l.defPos, l.rulePos)
+}
+
+// file loads a rule file, unless it was loaded already. includedAt is the
+// position of the include directive naming the file, if any.
+func (l *ruleLoader) file(path string, includedAt string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if l.seen[abs] {
+		return nil
+	}
+	l.seen[abs] = true
+	l.files = append(l.files, path)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if includedAt != "" {
+			return fmt.Errorf("%s: failed to include %s: %v", includedAt, path, err)
+		}
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+
+	ns := l.namespace(path)
+	switch root.Kind {
+	case yaml.SequenceNode:
+		return l.ruleList(path, ns, root)
+	case yaml.MappingNode:
+	default:
+		return fmt.Errorf("%s: failed to decode rules, found neither a list nor a map", pos(path, root))
+	}
+
+	var rules *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		k, v := root.Content[i], root.Content[i+1]
+		switch k.Value {
+		case "namespace":
+			if v.Kind != yaml.ScalarNode {
+				return fmt.Errorf("%s: failed to decode rules, namespace is not a string", pos(path, v))
+			}
+			ns = v.Value
+		case "include":
+			if err := l.include(path, v); err != nil {
+				return err
+			}
+		case "definitions":
+			if err := l.definitions(path, v); err != nil {
+				return err
+			}
+		case "rules":
+			rules = v
+		default:
+			return fmt.Errorf("%s: failed to decode rules, unknown key %q", pos(path, k), k.Value)
+		}
+	}
+
+	if rules == nil {
+		return nil
+	}
+	return l.ruleList(path, ns, rules)
+}
+
+// namespace returns the default namespace of a file.
+func (l *ruleLoader) namespace(path string) string {
+	if l.root == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(l.root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(path)
+	}
+	return filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel)))
+}
+
+// include loads the files named by an include directive.
+func (l *ruleLoader) include(path string, n *yaml.Node) error {
+	var patterns []*yaml.Node
+	switch n.Kind {
+	case yaml.ScalarNode:
+		patterns = []*yaml.Node{n}
+	case yaml.SequenceNode:
+		patterns = n.Content
+	default:
+		return fmt.Errorf("%s: failed to decode rules, include is not a string or a list", pos(path, n))
+	}
+
+	for _, p := range patterns {
+		if p.Kind != yaml.ScalarNode {
+			return fmt.Errorf("%s: failed to decode rules, include is not a string", pos(path, p))
+		}
+		pattern := p.Value
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: failed to include %s: %v", pos(path, p), p.Value, err)
+		}
+		if matches == nil {
+			// Report a missing file, unless the pattern matched nothing.
+			matches = []string{pattern}
+			if strings.ContainsAny(p.Value, `*?[\`) {
+				matches = nil
+			}
+		}
+		for _, m := range matches {
+			if err := l.file(m, pos(path, p)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// definitions decodes the named definitions of a file.
+func (l *ruleLoader) definitions(path string, n *yaml.Node) error {
+	if n.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: failed to decode rules, definitions is not a map", pos(path, n))
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		if first, ok := l.defPos[k.Value]; ok {
+			return fmt.Errorf("%s: duplicate definition %q, first defined at %s", pos(path, k), k.Value, first)
+		}
+		m, err := decodeNode(path, v)
+		if err != nil {
+			return err
+		}
+		l.defs[k.Value] = m
+		l.defPos[k.Value] = pos(path, k)
+	}
+	return nil
+}
+
+// ruleList decodes a list of rules, prefixing the IDs of Rules with the
+// namespace.
+func (l *ruleLoader) ruleList(path, ns string, n *yaml.Node) error {
+	if n.Kind != yaml.SequenceNode {
+		return fmt.Errorf("%s: failed to decode rules, rules is not a list", pos(path, n))
+	}
+
+	for _, item := range n.Content {
+		m, err := decodeNode(path, item)
+		if err != nil {
+			return err
+		}
+		if r, ok := m.(*Rule); ok {
+			if ns != "" {
+				r.ID = ns + "/" + r.ID
+			}
+			if first, ok := l.ids[r.ID]; ok {
+				return fmt.Errorf("%s: duplicate rule ID %q, first defined at %s", pos(path, item), r.ID, first)
+			}
+			l.ids[r.ID] = pos(path, item)
+		}
+		l.rules = append(l.rules, m)
+		l.rulePos = append(l.rulePos, pos(path, item))
+	}
+	return nil
+}
+
+// decodeNode decodes a matcher node, reporting errors at its position.
+func decodeNode(path string, n *yaml.Node) (Matcher, error) {
+	var v interface{}
+	if err := n.Decode(&v); err != nil {
+		return nil, fmt.Errorf("%s: %v", pos(path, n), err)
+	}
+	mm, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: failed to decode matcher into map", pos(path, n))
+	}
+	m, err := Decode(mm)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", pos(path, n), err)
+	}
+	return m, nil
+}
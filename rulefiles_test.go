@@ -0,0 +1,181 @@
+package matcher
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTree writes the files, given by their paths relative to dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "rules")
+	writeTree(t, root, map[string]string{
+		"shared/hosts.yaml": `
+definitions:
+  staging_host:
+    hostname_matcher:
+      match_type: prefix_match
+      hostname: staging-
+`,
+		"rules/team-a/network.yaml": `
+include: ../../shared/hosts.yaml
+rules:
+  - rule:
+      id: drop-cron
+      matcher:
+        n_ary_op:
+          type: and
+          matchers:
+            - ref_matcher:
+                name: staging_host
+            - value_matcher:
+                type: program
+                match_type: exact_match
+                value: cron
+`,
+		"rules/team-b.json": `{
+  "namespace": "b",
+  "rules": [
+    {"rule": {"id": "drop-cron", "matcher": {"constant_matcher": {"value": false}}}},
+    {"value_matcher": {"type": "program", "match_type": "exact_match", "value": "sshd"}}
+  ]
+}`,
+		"rules/.hidden/ignored.yaml": `- unknown_matcher: {}`,
+		"rules/README.md":            `not rules`,
+	})
+
+	rules, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+
+	var got []string
+	for _, r := range rules {
+		got = append(got, Expand(r).String())
+	}
+	want := []string{
+		`rule("team-a/network/drop-cron", (hostname(prefix_match, staging-) and program(exact_match, "cron")))`,
+		`rule("b/drop-cron", false)`,
+		`program(exact_match, "sshd")`,
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	// A single file is not namespaced, unless it declares a namespace.
+	rules, err = LoadFile(filepath.Join(dir, "team-a/network.yaml"))
+	if err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+	if want, got := "drop-cron", rules[0].(*Rule).ID; want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestLoadDirErrors(t *testing.T) {
+	for _, tc := range []struct {
+		files map[string]string
+		want  string
+	}{
+		{
+			map[string]string{
+				"a.yaml": "namespace: x\nrules:\n  - rule:\n      id: r\n      matcher: {constant_matcher: {value: true}}\n",
+				"b.yaml": "namespace: x\nrules:\n  - constant_matcher: {value: true}\n  - rule:\n      id: r\n      matcher: {constant_matcher: {value: true}}\n",
+			},
+			`b.yaml:4: duplicate rule ID "x/r", first defined at DIR/a.yaml:3`,
+		},
+		{
+			map[string]string{
+				"a.yaml": "rules:\n  - constant_matcher: {value: true}\n\n  - kv_matcher:\n      key: status\n",
+			},
+			`a.yaml:4: failed to decode kv matcher, missing fields`,
+		},
+		{
+			map[string]string{
+				"a.yaml": "include:\n  - missing.yaml\nrules: []\n",
+			},
+			`a.yaml:2: failed to include DIR/missing.yaml`,
+		},
+		{
+			map[string]string{
+				"a.yaml": "definitions:\n  d: {constant_matcher: {value: true}}\n",
+				"b.yaml": "\ndefinitions:\n  d: {constant_matcher: {value: true}}\n",
+			},
+			`b.yaml:3: duplicate definition "d", first defined at DIR/a.yaml:2`,
+		},
+		{
+			map[string]string{
+				"a.yaml": "rule: []\n",
+			},
+			`a.yaml:1: failed to decode rules, unknown key "rule"`,
+		},
+		{
+			map[string]string{
+				"a.json": "[{\"constant_matcher\": {\"value\": true}},\n {\"ref_matcher\": {\"name\": \"nope\"}}]",
+			},
+			`a.json:2: failed to decode ref matcher, undefined definition "nope"`,
+		},
+		{
+			map[string]string{
+				"a.yaml": "definitions:\n  a: {ref_matcher: {name: b}}\n",
+				"b.yaml": "definitions:\n  b:\n    ref_matcher: {name: a}\n",
+			},
+			`a.yaml:2: failed to decode ref matcher, cycle in definitions a -> b -> a`,
+		},
+	} {
+		dir := t.TempDir()
+		writeTree(t, dir, tc.files)
+		want := strings.ReplaceAll(tc.want, "DIR", dir)
+
+		_, err := LoadDir(dir)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("want error containing %q, got %v", want, err)
+		}
+	}
+}
+
+func TestLoadFileUndefinedRef(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a.yaml": "rules:\n  - rule:\n      id: r\n      matcher:\n        ref_matcher: {name: missing}\n",
+	})
+
+	path := filepath.Join(dir, "a.yaml")
+	_, err := LoadFile(path)
+	want := path + `:2: failed to decode ref matcher, undefined definition "missing"`
+	if err == nil || err.Error() != want {
+		t.Errorf("want != got, want = %v, got = %v", want, err)
+	}
+}
+
+func TestLoadDirIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a.yaml": "include: b.yaml\nrules:\n  - constant_matcher: {value: true}\n",
+		"b.yaml": "include: a.yaml\nrules:\n  - constant_matcher: {value: false}\n",
+	})
+
+	rules, err := LoadFile(filepath.Join(dir, "a.yaml"))
+	if err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+	if want, got := 2, len(rules); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
@@ -0,0 +1,370 @@
+package matcher
+
+// Simplify returns a simplified matcher equivalent to the supplied matcher.
+// The supplied tree is not modified, although leaf matchers may be shared
+// between the two trees.
+//
+// The following rewrites are applied bottom-up:
+//   - double negations are removed, and negations are pushed towards the
+//     leaves using De Morgan's laws;
+//   - implies is rewritten as an or of negations;
+//   - nested and/or operations of the same type are flattened;
+//   - constants are folded, and duplicate, complementary (x and not x) and
+//     absorbed (x and (x or y)) clauses are removed;
+//   - operations with a single matcher are replaced by the matcher, and
+//     threshold operations are reduced to and/or where possible.
+func Simplify(m Matcher) Matcher {
+	switch o := m.(type) {
+	case *UnaryOp:
+		if o.Type == Not {
+			return negate(Simplify(o.Matcher))
+		}
+		return NewUnaryOp(o.Type, Simplify(o.Matcher))
+	case *NAryOp:
+		cs := make(Matchers, len(o.Matchers))
+		for i, c := range o.Matchers {
+			cs[i] = Simplify(c)
+		}
+		return combine(o.Type, o.Count, cs)
+	case *AdaptiveOp:
+		return Simplify(o.frozen())
+	}
+
+	return m
+}
+
+// ToDNF returns the disjunctive normal form of the supplied matcher, an or of
+// ands of leaf matchers and their negations. Xor and threshold operations are
+// expanded, so the result may be exponentially larger than the input.
+func ToDNF(m Matcher) Matcher {
+	return fromClauses(Or, And, normalForm(expand(Simplify(m)), Or))
+}
+
+// ToCNF returns the conjunctive normal form of the supplied matcher, an and
+// of ors of leaf matchers and their negations. Xor and threshold operations
+// are expanded, so the result may be exponentially larger than the input.
+func ToCNF(m Matcher) Matcher {
+	return fromClauses(And, Or, normalForm(expand(Simplify(m)), And))
+}
+
+// negate returns the simplified negation of a simplified matcher.
+func negate(m Matcher) Matcher {
+	switch o := m.(type) {
+	case *Constant:
+		return NewConstant(!o.Value)
+	case *UnaryOp:
+		if o.Type == Not {
+			return o.Matcher
+		}
+	case *NAryOp:
+		switch o.Type {
+		case And, Or:
+			t := And
+			if o.Type == And {
+				t = Or
+			}
+			cs := make(Matchers, len(o.Matchers))
+			for i, c := range o.Matchers {
+				cs[i] = negate(c)
+			}
+			return combine(t, 0, cs)
+		case Xor:
+			cs := append(Matchers{negate(o.Matchers[0])}, o.Matchers[1:]...)
+			return combine(Xor, 0, cs)
+		case AtLeast:
+			// Fewer than n of the matchers match if more than len-n of their
+			// negations match.
+			cs := make(Matchers, len(o.Matchers))
+			for i, c := range o.Matchers {
+				cs[i] = negate(c)
+			}
+			return combine(AtLeast, len(cs)-o.Count+1, cs)
+		}
+	}
+
+	return NewUnaryOp(Not, m)
+}
+
+// combine returns the simplified n-ary operation of simplified matchers.
+func combine(t NAryOpType, n int, cs Matchers) Matcher {
+	switch t {
+	case And, Or:
+		return combineAndOr(t, cs)
+	case Implies:
+		if len(cs) == 0 {
+			return NewConstant(true)
+		}
+		out := make(Matchers, len(cs))
+		for i, c := range cs[:len(cs)-1] {
+			out[i] = negate(c)
+		}
+		out[len(cs)-1] = cs[len(cs)-1]
+		return combineAndOr(Or, out)
+	case Xor:
+		return combineXor(cs)
+	case AtLeast, Exactly:
+		return combineThreshold(t, n, cs)
+	}
+
+	return &NAryOp{Type: t, Matchers: cs, Count: n}
+}
+
+// combineAndOr returns the simplified and/or of simplified matchers.
+func combineAndOr(t NAryOpType, cs Matchers) Matcher {
+	// In an and, true is the identity and false the annihilator, and vice
+	// versa in an or.
+	identity := t == And
+
+	var flat Matchers
+	for _, c := range cs {
+		if o, ok := c.(*NAryOp); ok && o.Type == t {
+			flat = append(flat, o.Matchers...)
+		} else {
+			flat = append(flat, c)
+		}
+	}
+
+	var out Matchers
+	for _, c := range flat {
+		if k, ok := c.(*Constant); ok {
+			if k.Value == identity {
+				continue
+			}
+			return NewConstant(!identity)
+		}
+		if containsMatcher(out, c) {
+			continue
+		}
+		if containsMatcher(out, negate(c)) {
+			return NewConstant(!identity)
+		}
+		out = append(out, c)
+	}
+
+	// Absorption: x and (x or y) is x, and x or (x and y) is x.
+	var absorbed Matchers
+	for i, c := range out {
+		if o, ok := c.(*NAryOp); ok && (o.Type == And || o.Type == Or) && o.Type != t && absorbs(out, i, o) {
+			continue
+		}
+		absorbed = append(absorbed, c)
+	}
+
+	switch len(absorbed) {
+	case 0:
+		return NewConstant(identity)
+	case 1:
+		return absorbed[0]
+	}
+
+	return NewNAryOp(t, absorbed...)
+}
+
+// absorbs returns true if a sibling of the i'th matcher in cs is also one of
+// the matchers of o.
+func absorbs(cs Matchers, i int, o *NAryOp) bool {
+	for j, c := range cs {
+		if j != i && containsMatcher(o.Matchers, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// combineXor returns the simplified xor of simplified matchers.
+func combineXor(cs Matchers) Matcher {
+	invert := false
+	var out Matchers
+	for _, c := range cs {
+		if k, ok := c.(*Constant); ok {
+			invert = invert != k.Value
+			continue
+		}
+		// x xor x is false, so pairs of duplicates cancel out.
+		if i := indexMatcher(out, c); i >= 0 {
+			out = append(out[:i:i], out[i+1:]...)
+			continue
+		}
+		out = append(out, c)
+	}
+
+	var r Matcher
+	switch len(out) {
+	case 0:
+		r = NewConstant(false)
+	case 1:
+		r = out[0]
+	default:
+		r = NewNAryOp(Xor, out...)
+	}
+
+	if invert {
+		return negate(r)
+	}
+	return r
+}
+
+// combineThreshold returns the simplified at_least or exactly operation of
+// simplified matchers.
+func combineThreshold(t NAryOpType, n int, cs Matchers) Matcher {
+	var out Matchers
+	for _, c := range cs {
+		if k, ok := c.(*Constant); ok {
+			if k.Value {
+				n--
+			}
+			continue
+		}
+		out = append(out, c)
+	}
+
+	if n < 0 {
+		return NewConstant(t == AtLeast)
+	}
+	if n > len(out) {
+		return NewConstant(false)
+	}
+
+	switch {
+	case t == AtLeast && n == 0:
+		return NewConstant(true)
+	case t == AtLeast && n == 1:
+		return combineAndOr(Or, out)
+	case n == len(out):
+		return combineAndOr(And, out)
+	case t == Exactly && n == 0:
+		neg := make(Matchers, len(out))
+		for i, c := range out {
+			neg[i] = negate(c)
+		}
+		return combineAndOr(And, neg)
+	}
+
+	return NewThresholdOp(t, n, out...)
+}
+
+// expand rewrites a simplified matcher into a simplified tree of and, or and
+// not operations only.
+func expand(m Matcher) Matcher {
+	switch o := m.(type) {
+	case *UnaryOp:
+		if o.Type == Not {
+			if _, ok := o.Matcher.(*NAryOp); ok {
+				return negate(expand(o.Matcher))
+			}
+		}
+		return m
+	case *NAryOp:
+		cs := make(Matchers, len(o.Matchers))
+		for i, c := range o.Matchers {
+			cs[i] = expand(c)
+		}
+		switch o.Type {
+		case Xor:
+			r := cs[0]
+			for _, c := range cs[1:] {
+				r = combineAndOr(Or, Matchers{
+					combineAndOr(And, Matchers{r, negate(c)}),
+					combineAndOr(And, Matchers{negate(r), c}),
+				})
+			}
+			return r
+		case AtLeast, Exactly:
+			return expandThreshold(o.Type, o.Count, cs)
+		}
+		return combine(o.Type, o.Count, cs)
+	}
+
+	return m
+}
+
+// expandThreshold rewrites a threshold operation over expanded matchers into
+// and, or and not operations, by deciding on the first matcher and recursing
+// on the rest.
+func expandThreshold(t NAryOpType, n int, cs Matchers) Matcher {
+	if n < 0 || n > len(cs) {
+		return NewConstant(false)
+	}
+	if len(cs) == 0 || (t == AtLeast && n == 0) {
+		return NewConstant(true)
+	}
+
+	first, rest := cs[0], cs[1:]
+	without := expandThreshold(t, n, rest)
+	if t == Exactly {
+		without = combineAndOr(And, Matchers{negate(first), without})
+	}
+
+	return combineAndOr(Or, Matchers{
+		combineAndOr(And, Matchers{first, expandThreshold(t, n-1, rest)}),
+		without,
+	})
+}
+
+// normalForm returns the clauses of an expanded matcher in the normal form
+// whose outer operation is outer, i.e. a list of ands of literals for an outer
+// or, or a list of ors of literals for an outer and.
+func normalForm(m Matcher, outer NAryOpType) []Matchers {
+	inner := And
+	if outer == And {
+		inner = Or
+	}
+
+	o, ok := m.(*NAryOp)
+	if !ok {
+		if k, ok := m.(*Constant); ok && k.Value == (outer == And) {
+			// The identity of the outer operation has no clauses.
+			return nil
+		}
+		return []Matchers{{m}}
+	}
+
+	switch o.Type {
+	case outer:
+		var out []Matchers
+		for _, c := range o.Matchers {
+			out = append(out, normalForm(c, outer)...)
+		}
+		return out
+	case inner:
+		// Distribute the inner operation over the clauses of each matcher.
+		out := []Matchers{{}}
+		for _, c := range o.Matchers {
+			var next []Matchers
+			for _, a := range out {
+				for _, b := range normalForm(c, outer) {
+					clause := append(append(Matchers{}, a...), b...)
+					next = append(next, clause)
+				}
+			}
+			out = next
+		}
+		return out
+	}
+
+	return []Matchers{{m}}
+}
+
+// fromClauses builds a simplified normal form from its clauses.
+func fromClauses(outer, inner NAryOpType, clauses []Matchers) Matcher {
+	cs := make(Matchers, len(clauses))
+	for i, clause := range clauses {
+		cs[i] = combineAndOr(inner, clause)
+	}
+	return combineAndOr(outer, cs)
+}
+
+// containsMatcher returns true if cs contains a matcher equal to m.
+func containsMatcher(cs Matchers, m Matcher) bool {
+	return indexMatcher(cs, m) >= 0
+}
+
+// indexMatcher returns the index of the first matcher in cs equal to m, or -1.
+func indexMatcher(cs Matchers, m Matcher) int {
+	for i, c := range cs {
+		if equal(c, m) {
+			return i
+		}
+	}
+	return -1
+}
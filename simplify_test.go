@@ -0,0 +1,187 @@
+package matcher
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// randomLeaves are the leaf matchers used to build random trees.
+var randomLeaves = []Matcher{
+	NewHostname(ExactMatch, "a"),
+	NewHostname(PrefixMatch, "a"),
+	NewHostname(ExactMatch, "b"),
+	// Hostname never matches equals.
+	NewHostname(Equals, "a"),
+	NewValue(Program, ExactMatch, "x"),
+	NewValue(Program, ExactMatch, "y"),
+	NewSeverity(LessThan, captainslog.Warning),
+	NewSeverity(GreaterThanEqual, captainslog.Err),
+	NewConstant(true),
+	NewConstant(false),
+}
+
+// randomTree returns a random matcher tree of at most the specified depth.
+func randomTree(r *rand.Rand, depth int) Matcher {
+	if depth == 0 || r.Intn(4) == 0 {
+		return randomLeaves[r.Intn(len(randomLeaves))]
+	}
+
+	if r.Intn(5) == 0 {
+		return NewUnaryOp(Not, randomTree(r, depth-1))
+	}
+
+	cs := make(Matchers, r.Intn(4))
+	for i := range cs {
+		cs[i] = randomTree(r, depth-1)
+	}
+	if r.Intn(4) == 0 {
+		// Duplicate a clause to exercise deduplication and absorption.
+		cs = append(cs, randomTree(r, 0))
+		cs = append(cs, cs[len(cs)-1])
+	}
+
+	t := NAryOpType(r.Intn(6))
+	if t.IsThreshold() {
+		return NewThresholdOp(t, r.Intn(len(cs)+2)-1, cs...)
+	}
+	if t == Xor && len(cs) == 0 {
+		t = Or
+	}
+	return NewNAryOp(t, cs...)
+}
+
+// allMessages returns messages covering every combination of the fields
+// used by randomLeaves.
+func allMessages() []captainslog.SyslogMsg {
+	var out []captainslog.SyslogMsg
+	for _, host := range []string{"a", "ab", "b"} {
+		for _, program := range []string{"x", "y", "z"} {
+			for sev := captainslog.Emerg; sev <= captainslog.Debug; sev++ {
+				m := captainslog.NewSyslogMsg()
+				m.Host = host
+				m.SetProgram(program)
+				_ = m.SetSeverity(sev)
+				out = append(out, m)
+			}
+		}
+	}
+	return out
+}
+
+// isNormalForm returns true if m is an outer operation of inner operations of
+// leaf matchers and their negations.
+func isNormalForm(m Matcher, outer, inner NAryOpType) bool {
+	isLiteral := func(m Matcher) bool {
+		if o, ok := m.(*UnaryOp); ok {
+			m = o.Matcher
+		}
+		switch m.(type) {
+		case *UnaryOp, *NAryOp:
+			return false
+		}
+		return true
+	}
+	isClause := func(m Matcher) bool {
+		if o, ok := m.(*NAryOp); ok {
+			if o.Type != inner {
+				return false
+			}
+			for _, c := range o.Matchers {
+				if !isLiteral(c) {
+					return false
+				}
+			}
+			return true
+		}
+		return isLiteral(m)
+	}
+
+	if o, ok := m.(*NAryOp); ok && o.Type == outer {
+		for _, c := range o.Matchers {
+			if !isClause(c) {
+				return false
+			}
+		}
+		return true
+	}
+	return isClause(m)
+}
+
+func TestSimplifyRandomized(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	msgs := allMessages()
+
+	for i := 0; i < 2000; i++ {
+		tree := randomTree(r, 4)
+		before := tree.String()
+
+		simplified := Simplify(tree)
+		dnf := ToDNF(tree)
+		cnf := ToCNF(tree)
+
+		if tree.String() != before {
+			t.Fatalf("Simplify modified its input %s", before)
+		}
+		if !isNormalForm(dnf, Or, And) {
+			t.Errorf("%s is not in DNF", dnf)
+		}
+		if !isNormalForm(cnf, And, Or) {
+			t.Errorf("%s is not in CNF", cnf)
+		}
+
+		for _, m := range msgs {
+			want := tree.Matches(m)
+			if got := simplified.Matches(m); want != got {
+				t.Fatalf("Simplify(%s) = %s, want = %v, got = %v for %s", tree, simplified, want, got, m.String())
+			}
+			if got := dnf.Matches(m); want != got {
+				t.Fatalf("ToDNF(%s) = %s, want = %v, got = %v for %s", tree, dnf, want, got, m.String())
+			}
+			if got := cnf.Matches(m); want != got {
+				t.Fatalf("ToCNF(%s) = %s, want = %v, got = %v for %s", tree, cnf, want, got, m.String())
+			}
+		}
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	a := NewHostname(ExactMatch, "a")
+	b := NewValue(Program, ExactMatch, "b")
+	c := NewValue(Content, Contains, "c")
+
+	for _, tc := range []struct {
+		in   Matcher
+		want string
+	}{
+		{NewUnaryOp(Not, NewUnaryOp(Not, a)), a.String()},
+		{NewNAryOp(And, a), a.String()},
+		{NewNAryOp(And, a, NewNAryOp(And, b, c)), NewNAryOp(And, a, b, c).String()},
+		{NewNAryOp(Or, a, b, a), NewNAryOp(Or, a, b).String()},
+		{NewNAryOp(And, a, NewConstant(true)), a.String()},
+		{NewNAryOp(Or, a, NewConstant(true)), "true"},
+		{NewNAryOp(And, a, NewUnaryOp(Not, a)), "false"},
+		{NewNAryOp(And, a, NewNAryOp(Or, a, b)), a.String()},
+		{NewNAryOp(Or, a, NewNAryOp(And, a, b)), a.String()},
+		{NewUnaryOp(Not, NewNAryOp(And, a, b)), NewNAryOp(Or, NewUnaryOp(Not, a), NewUnaryOp(Not, b)).String()},
+		{NewNAryOp(Implies, a, b), NewNAryOp(Or, NewUnaryOp(Not, a), b).String()},
+		{NewThresholdOp(AtLeast, 1, a, b), NewNAryOp(Or, a, b).String()},
+		{NewThresholdOp(Exactly, 2, a, b), NewNAryOp(And, a, b).String()},
+		{NewNAryOp(Xor, a, a, b), b.String()},
+	} {
+		if want, got := tc.want, Simplify(tc.in).String(); want != got {
+			t.Errorf("Simplify(%s): want != got, want = %v, got = %v", tc.in, want, got)
+		}
+	}
+
+	dnf := ToDNF(NewNAryOp(And, NewNAryOp(Or, a, b), c))
+	if want, got := NewNAryOp(Or, NewNAryOp(And, a, c), NewNAryOp(And, b, c)).String(), dnf.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	cnf := ToCNF(NewNAryOp(Or, NewNAryOp(And, a, b), c))
+	if want, got := NewNAryOp(And, NewNAryOp(Or, a, c), NewNAryOp(Or, b, c)).String(), cnf.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
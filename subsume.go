@@ -0,0 +1,114 @@
+package matcher
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Entails returns true if every message matched by a is also matched by b,
+// i.e. a and not b can never match. It uses the same reasoning as Analyze, so
+// it may return false for rules which do imply each other.
+func Entails(a, b Matcher) bool {
+	_, unsat, ok := unsatisfiable(NewNAryOp(And, a, NewUnaryOp(Not, b)))
+	return ok && unsat
+}
+
+// Disjoint returns true if no message is matched by both a and b. It uses the
+// same reasoning as Analyze, so it may return false for disjoint rules.
+func Disjoint(a, b Matcher) bool {
+	_, unsat, ok := unsatisfiable(NewNAryOp(And, a, b))
+	return ok && unsat
+}
+
+// Redundancy records that a rule of a set is covered by another rule.
+type Redundancy struct {
+	// Rule is the index of the redundant rule.
+	Rule int
+	// CoveredBy is the index of the rule implied by the redundant rule.
+	CoveredBy int
+	// Equivalent is true if the two rules also imply each other.
+	Equivalent bool
+}
+
+// Overlap records that two rules of a set may match the same messages,
+// although neither implies the other.
+type Overlap struct {
+	A int
+	B int
+}
+
+// SetReport is the result of analyzing the relations between the rules of a
+// set.
+type SetReport struct {
+	Rules       Matchers
+	Redundant   []Redundancy
+	Overlapping []Overlap
+}
+
+// AnalyzeSet reports the rules of the set which are covered by another rule,
+// and the pairs of rules which may match the same messages. Of two equivalent
+// rules, only the later one is reported as redundant.
+func AnalyzeSet(ms Matchers) SetReport {
+	r := SetReport{Rules: ms}
+
+	for i := range ms {
+		for j := i + 1; j < len(ms); j++ {
+			ij := Entails(ms[i], ms[j])
+			ji := Entails(ms[j], ms[i])
+
+			switch {
+			case ij && ji:
+				r.Redundant = append(r.Redundant, Redundancy{Rule: j, CoveredBy: i, Equivalent: true})
+			case ij:
+				r.Redundant = append(r.Redundant, Redundancy{Rule: i, CoveredBy: j})
+			case ji:
+				r.Redundant = append(r.Redundant, Redundancy{Rule: j, CoveredBy: i})
+			case !Disjoint(ms[i], ms[j]):
+				r.Overlapping = append(r.Overlapping, Overlap{A: i, B: j})
+			}
+		}
+	}
+
+	return r
+}
+
+// CoveredBy returns the indices of the rules of the set which imply the
+// supplied rule, e.g. to check whether a new exclusion is already covered by
+// an existing one.
+func CoveredBy(ms Matchers, m Matcher) []int {
+	var out []int
+	for i, c := range ms {
+		if Entails(m, c) {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// OverlapsWith returns the indices of the rules of the set which may match
+// some of the messages matched by the supplied rule.
+func OverlapsWith(ms Matchers, m Matcher) []int {
+	var out []int
+	for i, c := range ms {
+		if !Disjoint(m, c) {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// String converts a SetReport to its corresponding string representation.
+func (r SetReport) String() string {
+	var b bytes.Buffer
+	for _, d := range r.Redundant {
+		if d.Equivalent {
+			fmt.Fprintf(&b, "rule %d %s is equivalent to rule %d %s\n", d.Rule, r.Rules[d.Rule], d.CoveredBy, r.Rules[d.CoveredBy])
+		} else {
+			fmt.Fprintf(&b, "rule %d %s is covered by rule %d %s\n", d.Rule, r.Rules[d.Rule], d.CoveredBy, r.Rules[d.CoveredBy])
+		}
+	}
+	for _, o := range r.Overlapping {
+		fmt.Fprintf(&b, "rule %d %s overlaps rule %d %s\n", o.A, r.Rules[o.A], o.B, r.Rules[o.B])
+	}
+	return b.String()
+}
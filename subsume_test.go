@@ -0,0 +1,102 @@
+package matcher
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestEntails(t *testing.T) {
+	staging := NewHostname(PrefixMatch, "staging-")
+	stagingAPI := NewHostname(PrefixMatch, "staging-api-")
+	debug := NewSeverity(Equals, captainslog.Debug)
+	belowWarn := NewSeverity(LessThan, captainslog.Warning)
+
+	for _, tc := range []struct {
+		a, b Matcher
+		want bool
+	}{
+		{stagingAPI, staging, true},
+		{staging, stagingAPI, false},
+		{debug, belowWarn, true},
+		{belowWarn, debug, false},
+		{NewNAryOp(And, staging, debug), staging, true},
+		{staging, NewNAryOp(Or, staging, debug), true},
+		{NewNAryOp(And, stagingAPI, debug), NewNAryOp(And, staging, belowWarn), true},
+		{NewKV("latency", GreaterThan, 500), NewKV("latency", GreaterThan, 100), true},
+		{NewKV("latency", GreaterThan, 100), NewKV("latency", GreaterThan, 500), false},
+		{NewHostname(ExactMatch, "staging-db-1"), staging, true},
+	} {
+		if want, got := tc.want, Entails(tc.a, tc.b); want != got {
+			t.Errorf("Entails(%s, %s): want != got, want = %v, got = %v", tc.a, tc.b, want, got)
+		}
+	}
+}
+
+func TestAnalyzeSet(t *testing.T) {
+	rules := Matchers{
+		NewHostname(PrefixMatch, "staging-"),
+		NewNAryOp(And, NewHostname(PrefixMatch, "staging-api-"), NewValue(Program, ExactMatch, "nginx")),
+		NewValue(Program, ExactMatch, "nginx"),
+		NewHostname(PrefixMatch, "prod-"),
+		NewNAryOp(Or, NewHostname(PrefixMatch, "prod-")),
+	}
+
+	r := AnalyzeSet(rules)
+
+	if want, got := 3, len(r.Redundant); want != got {
+		t.Fatalf("want != got, want = %v, got = %v\n%s", want, got, r)
+	}
+	for i, want := range []Redundancy{{1, 0, false}, {1, 2, false}, {4, 3, true}} {
+		if got := r.Redundant[i]; want != got {
+			t.Errorf("want != got, want = %v, got = %v", want, got)
+		}
+	}
+
+	// Staging and prod hosts are disjoint, any host may run nginx.
+	for i, want := range []Overlap{{0, 2}, {2, 3}, {2, 4}} {
+		if i >= len(r.Overlapping) {
+			t.Errorf("missing overlap %v\n%s", want, r)
+			continue
+		}
+		if got := r.Overlapping[i]; want != got {
+			t.Errorf("want != got, want = %v, got = %v", want, got)
+		}
+	}
+	if want, got := 3, len(r.Overlapping); want != got {
+		t.Errorf("want != got, want = %v, got = %v\n%s", want, got, r)
+	}
+
+	if want, got := "[0 2]", fmt.Sprint(CoveredBy(rules, NewNAryOp(And, NewHostname(ExactMatch, "staging-1"), NewValue(Program, ExactMatch, "nginx")))); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := "[2 3 4]", fmt.Sprint(OverlapsWith(rules, NewHostname(ExactMatch, "prod-1"))); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestEntailsRandomized(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	msgs := allMessages()
+
+	found := 0
+	for i := 0; i < 1000; i++ {
+		a := randomTree(r, 2)
+		b := randomTree(r, 2)
+		if !Entails(a, b) {
+			continue
+		}
+		found++
+		for _, m := range msgs {
+			if a.Matches(m) && !b.Matches(m) {
+				t.Fatalf("Entails(%s, %s) but %s matches only the first", a, b, m.String())
+			}
+		}
+	}
+
+	if found == 0 {
+		t.Errorf("randomized trees did not exercise Entails")
+	}
+}
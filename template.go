@@ -0,0 +1,309 @@
+package matcher
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VarType is the enum class for representing the types of template variables
+// which stand for a whole typed value.
+type VarType int
+
+// Variable types.
+const (
+	NumberVar VarType = iota
+	BoolVar
+	DurationVar
+)
+
+// String converts a VarType to its corresponding string representation.
+func (t VarType) String() string {
+	switch t {
+	case NumberVar:
+		return "number"
+	case BoolVar:
+		return "bool"
+	case DurationVar:
+		return "duration"
+	default:
+		return "invalid type"
+	}
+}
+
+// FromString converts the VarType to the value corresponding to the supplied
+// string representation.
+func (t *VarType) FromString(s string) error {
+	switch s {
+	case "number":
+		*t = NumberVar
+	case "bool":
+		*t = BoolVar
+	case "duration":
+		*t = DurationVar
+	default:
+		return fmt.Errorf("failed to convert string to VarType")
+	}
+
+	return nil
+}
+
+// Var is a placeholder, written ${name}, for the number, bool or duration
+// value of a KV or Capture matcher. A matcher holding a Var never matches
+// until the Var is substituted by Bind.
+type Var struct {
+	Name string
+	Type VarType
+}
+
+// String converts a Var to its corresponding string representation.
+func (v Var) String() string {
+	return "${" + v.Name + "}"
+}
+
+// Vars maps the names of template variables to their values.
+type Vars map[string]interface{}
+
+// varPattern matches a ${name} placeholder.
+var varPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// parseVar returns the Var of the supplied type if s consists of a single
+// placeholder.
+func parseVar(s string, t VarType) (Var, bool) {
+	loc := varPattern.FindStringSubmatchIndex(s)
+	if loc == nil || loc[0] != 0 || loc[1] != len(s) {
+		return Var{}, false
+	}
+	return Var{Name: s[loc[2]:loc[3]], Type: t}, true
+}
+
+// templated returns true if the leaf matcher references a variable.
+func templated(m Matcher) bool {
+	found := false
+	visitVars(m, func(string) { found = true })
+	return found
+}
+
+// visitVars calls fn with the name of every variable referenced by the leaf
+// matcher.
+func visitVars(m Matcher, fn func(string)) {
+	str := func(s string) {
+		for _, sub := range varPattern.FindAllStringSubmatch(s, -1) {
+			fn(sub[1])
+		}
+	}
+	val := func(v interface{}) {
+		switch x := v.(type) {
+		case Var:
+			fn(x.Name)
+		case string:
+			str(x)
+		}
+	}
+
+	switch o := m.(type) {
+	case *Hostname:
+		str(o.NameMatcher)
+	case *Value:
+		str(o.Value)
+	case *KV:
+		str(o.Key)
+		val(o.Value)
+	case *Capture:
+		str(o.Pattern)
+		val(o.Value)
+	}
+}
+
+// Variables returns the sorted names of the variables referenced by the
+// matcher tree.
+func Variables(m Matcher) []string {
+	seen := make(map[string]bool)
+	Walk(m, func(node Matcher, path Path) bool {
+		visitVars(node, func(name string) { seen[name] = true })
+		return true
+	})
+
+	out := make([]string, 0, len(seen))
+	for name := range seen {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Bind returns a copy of the matcher tree with every variable substituted by
+// its value. Placeholders inside hostnames, values, KV keys and string values,
+// and capture patterns are replaced by the text of a string or number value.
+// A Var is replaced by a value of its type; strings are parsed, so values may
+// come from the command line. Bind fails on unbound variables and on values of
+// the wrong type. The input tree is not modified.
+func Bind(m Matcher, vars Vars) (Matcher, error) {
+	var err error
+	out := Transform(m, func(n Matcher) Matcher {
+		if err != nil {
+			return n
+		}
+		var b Matcher
+		b, err = bindLeaf(n, vars)
+		return b
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// bindLeaf returns a copy of the leaf matcher with its variables substituted,
+// or the matcher itself if it has none.
+func bindLeaf(m Matcher, vars Vars) (Matcher, error) {
+	if !templated(m) {
+		return m, nil
+	}
+
+	switch o := m.(type) {
+	case *Hostname:
+		n, err := interpolate(o.NameMatcher, vars)
+		if err != nil {
+			return nil, err
+		}
+		return NewHostname(o.MatchType, n), nil
+	case *Value:
+		v, err := interpolate(o.Value, vars)
+		if err != nil {
+			return nil, err
+		}
+		return NewValue(o.Type, o.MatchType, v), nil
+	case *KV:
+		k, err := interpolate(o.Key, vars)
+		if err != nil {
+			return nil, err
+		}
+		v, err := bindValue(o.Value, vars)
+		if err != nil {
+			return nil, err
+		}
+		return &KV{Key: k, MatchType: o.MatchType, Value: v, Coercion: o.Coercion, Format: o.Format, path: parseKey(k)}, nil
+	case *Capture:
+		pattern, err := interpolate(o.Pattern, vars)
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if captureIndex(re, o.Group) < 0 {
+			return nil, fmt.Errorf("failed to bind capture matcher, pattern has no group %q", o.Group)
+		}
+		v, err := bindValue(o.Value, vars)
+		if err != nil {
+			return nil, err
+		}
+		return &Capture{Field: o.Field, Pattern: pattern, Group: o.Group, MatchType: o.MatchType, Value: v, re: re}, nil
+	}
+
+	return m, nil
+}
+
+// lookupVar returns the value of the named variable.
+func lookupVar(name string, vars Vars) (interface{}, error) {
+	v, ok := vars[name]
+	if !ok {
+		return nil, fmt.Errorf("failed to bind template, unbound variable %q", name)
+	}
+	return v, nil
+}
+
+// interpolate replaces the placeholders of s by the text of their string or
+// number values.
+func interpolate(s string, vars Vars) (string, error) {
+	var err error
+	out := varPattern.ReplaceAllStringFunc(s, func(p string) string {
+		name := p[2 : len(p)-1]
+		v, e := lookupVar(name, vars)
+		if e != nil {
+			if err == nil {
+				err = e
+			}
+			return p
+		}
+
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.String:
+			return rv.String()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return strconv.FormatInt(rv.Int(), 10)
+		case reflect.Float32, reflect.Float64:
+			return strconv.FormatFloat(rv.Float(), 'g', -1, 64)
+		}
+		if err == nil {
+			err = fmt.Errorf("failed to bind template, variable %q is a %T, not a string or number", name, v)
+		}
+		return p
+	})
+	return out, err
+}
+
+// bindValue substitutes the Var or the placeholders of a string KV or
+// Capture value.
+func bindValue(v interface{}, vars Vars) (interface{}, error) {
+	switch x := v.(type) {
+	case string:
+		return interpolate(x, vars)
+	case Var:
+		val, err := lookupVar(x.Name, vars)
+		if err != nil {
+			return nil, err
+		}
+		out, ok := convertVar(val, x.Type)
+		if !ok {
+			return nil, fmt.Errorf("failed to bind template, variable %q is not a %s", x.Name, x.Type)
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// convertVar returns the value converted to the representation of the
+// variable type, parsing strings.
+func convertVar(v interface{}, t VarType) (interface{}, bool) {
+	if s, ok := v.(string); ok {
+		s = strings.TrimSpace(s)
+		switch t {
+		case NumberVar:
+			f, err := strconv.ParseFloat(s, 64)
+			return f, err == nil
+		case BoolVar:
+			b, err := strconv.ParseBool(s)
+			return b, err == nil
+		case DurationVar:
+			d, err := time.ParseDuration(s)
+			return d, err == nil
+		}
+		return nil, false
+	}
+
+	switch t {
+	case NumberVar:
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(rv.Int()), true
+		case reflect.Float32, reflect.Float64:
+			return rv.Float(), true
+		}
+	case BoolVar:
+		b, ok := v.(bool)
+		return b, ok
+	case DurationVar:
+		d, ok := v.(time.Duration)
+		return d, ok
+	}
+	return nil, false
+}
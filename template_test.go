@@ -0,0 +1,119 @@
+package matcher
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// templateRule returns a rule decoded from its JSON encoding, as read from a
+// rules file.
+func templateRule(t *testing.T) Matcher {
+	in := map[string]interface{}{}
+	err := json.Unmarshal([]byte(`{"n_ary_op": {"type": "and", "matchers": [
+		{"hostname_matcher": {"match_type": "prefix_match", "hostname": "${region}-api-"}},
+		{"kv_matcher": {"key": "latency", "match_type": "gt", "num_value": "${threshold}"}},
+		{"capture_matcher": {
+			"field": {"type": "content"},
+			"pattern": "took ([0-9a-z.]+)",
+			"match_type": "gt",
+			"duration_value": "${slow}"}}
+	]}}`), &in)
+	if err != nil {
+		t.Fatalf("failed to unmarshal rule: %v", err)
+	}
+	m, err := Decode(in)
+	if err != nil {
+		t.Fatalf("failed to decode rule: %v", err)
+	}
+	return m
+}
+
+func TestTemplate(t *testing.T) {
+	rule := templateRule(t)
+
+	want := `(hostname(prefix_match, ${region}-api-) and kv("latency", gt, ${threshold}) and capture(content, "took ([0-9a-z.]+)", "", gt, ${slow}))`
+	if got := rule.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if want, got := []string{"region", "slow", "threshold"}, Variables(rule); !reflect.DeepEqual(want, got) {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	// Placeholders survive a round trip through the encoding.
+	out := make(map[string]interface{})
+	Encode(rule, out)
+	b, err := json.Marshal(out)
+	if err != nil {
+		t.Fatalf("failed to marshal rule: %v", err)
+	}
+	in := make(map[string]interface{})
+	if err := json.Unmarshal(b, &in); err != nil {
+		t.Fatalf("failed to unmarshal rule: %v", err)
+	}
+	decoded, err := Decode(in)
+	if err != nil {
+		t.Fatalf("failed to decode encoded rule: %v", err)
+	}
+	if !Equal(rule, decoded) {
+		t.Errorf("want = %v, got = %v", rule, decoded)
+	}
+
+	bound, err := Bind(rule, Vars{"region": "nyc3", "threshold": 250, "slow": "1.5s"})
+	if err != nil {
+		t.Fatalf("failed to bind rule: %v", err)
+	}
+	want = `(hostname(prefix_match, nyc3-api-) and kv("latency", gt, 250) and capture(content, "took ([0-9a-z.]+)", "", gt, duration("1.5s")))`
+	if got := bound.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if got := Variables(bound); len(got) != 0 {
+		t.Errorf("bound rule still has variables %v", got)
+	}
+	if want := templateRule(t).String(); rule.String() != want {
+		t.Errorf("Bind modified its input %s", rule)
+	}
+
+	m := captainslog.NewSyslogMsg()
+	m.Host = "nyc3-api-1"
+	m.Content = "took 2s"
+	m.IsJSON = true
+	m.JSONValues = map[string]interface{}{"latency": 300.0}
+	if rule.Matches(m) {
+		t.Errorf("unbound template matched")
+	}
+	if !bound.Matches(m) {
+		t.Errorf("bound template did not match")
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	rule := templateRule(t)
+
+	for _, vars := range []Vars{
+		{"region": "nyc3", "threshold": 250},
+		{"region": "nyc3", "threshold": "high", "slow": "1s"},
+		{"region": "nyc3", "threshold": 250, "slow": time.Second.Seconds()},
+		{"region": true, "threshold": 250, "slow": "1s"},
+	} {
+		if _, err := Bind(rule, vars); err == nil {
+			t.Errorf("Bind(%v) should have failed", vars)
+		}
+	}
+
+	_, err := Bind(NewKV("${env}.latency", GreaterThan, Var{Name: "max", Type: NumberVar}), Vars{"env": "prod"})
+	if want, got := `failed to bind template, unbound variable "max"`, err; got == nil || want != got.Error() {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	bound, err := Bind(NewKV("${env}.cached", Equals, Var{Name: "cached", Type: BoolVar}), Vars{"env": "prod", "cached": "true"})
+	if err != nil {
+		t.Fatalf("failed to bind rule: %v", err)
+	}
+	if want, got := `kv("prod.cached", equals, true)`, bound.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
@@ -60,11 +60,19 @@ func (o UnaryOp) String() string {
 
 // Matches returns true if the UnaryOp matches the supplied SyslogMsg.
 func (o *UnaryOp) Matches(m captainslog.SyslogMsg) bool {
+	matched, _ := o.matchesContent(m, nil)
+	return matched
+}
+
+// matchesContent returns true if the UnaryOp matches the supplied SyslogMsg,
+// whose logfmt content is parsed by c, along with c.
+func (o *UnaryOp) matchesContent(m captainslog.SyslogMsg, c *logfmtContent) (bool, *logfmtContent) {
 	switch o.Type {
 	case Not:
-		return !o.Matcher.Matches(m)
+		matched, c := matchContent(o.Matcher, m, c)
+		return !matched, c
 	default:
-		return false
+		return false, c
 	}
 }
 
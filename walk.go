@@ -0,0 +1,84 @@
+package matcher
+
+// Parent is implemented by matchers which contain other matchers, such as
+// UnaryOp and NAryOp. Custom matchers which wrap other matchers implement it
+// to take part in Walk and Transform.
+type Parent interface {
+	Matcher
+	// Children returns the matchers contained in this matcher, in order.
+	Children() Matchers
+	// WithChildren returns a copy of this matcher with its children replaced
+	// by the supplied matchers, which are as many as returned by Children.
+	WithChildren(Matchers) Matcher
+}
+
+// Children returns the matcher of the UnaryOp.
+func (o *UnaryOp) Children() Matchers {
+	return Matchers{o.Matcher}
+}
+
+// WithChildren returns a copy of the UnaryOp applied to the supplied matcher.
+func (o *UnaryOp) WithChildren(cs Matchers) Matcher {
+	return NewUnaryOp(o.Type, cs[0])
+}
+
+// Children returns the matchers of the NAryOp.
+func (o *NAryOp) Children() Matchers {
+	return o.Matchers
+}
+
+// WithChildren returns a copy of the NAryOp applied to the supplied matchers.
+func (o *NAryOp) WithChildren(cs Matchers) Matcher {
+	return &NAryOp{Type: o.Type, Matchers: cs, Count: o.Count}
+}
+
+// Walk calls fn for every node of a matcher tree in depth-first order, parents
+// before their children, with the path of the node. If fn returns false, the
+// children of the node are skipped.
+func Walk(m Matcher, fn func(node Matcher, path Path) bool) {
+	walk(m, Path{}, fn)
+}
+
+func walk(m Matcher, p Path, fn func(Matcher, Path) bool) {
+	if !fn(m, p) {
+		return
+	}
+	if o, ok := m.(Parent); ok {
+		for i, c := range o.Children() {
+			walk(c, p.Child(i), fn)
+		}
+	}
+}
+
+// Transform returns a copy of a matcher tree in which every node is replaced
+// by the result of fn. The tree is rewritten bottom-up, so fn receives a node
+// whose children have already been transformed. Parent nodes are copied
+// before being passed to fn, so the input tree is never modified.
+func Transform(m Matcher, fn func(Matcher) Matcher) Matcher {
+	if o, ok := m.(Parent); ok {
+		children := o.Children()
+		cs := make(Matchers, len(children))
+		for i, c := range children {
+			cs[i] = Transform(c, fn)
+		}
+		m = o.WithChildren(cs)
+	}
+	return fn(m)
+}
+
+// instrument returns a copy of a matcher tree in which every node is wrapped,
+// e.g. to record its evaluations. hook is called for every node of the input
+// tree in the order of Walk, with the path of the node, and returns the
+// function wrapping the copy of the node, whose children are wrapped already.
+func instrument(m Matcher, p Path, hook func(node Matcher, path Path) func(Matcher) Matcher) Matcher {
+	wrap := hook(m, p)
+	if o, ok := m.(Parent); ok {
+		children := o.Children()
+		cs := make(Matchers, len(children))
+		for i, c := range children {
+			cs[i] = instrument(c, p.Child(i), hook)
+		}
+		m = o.WithChildren(cs)
+	}
+	return wrap(m)
+}
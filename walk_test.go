@@ -0,0 +1,118 @@
+package matcher
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// labeled is a custom matcher wrapping another matcher.
+type labeled struct {
+	Label   string
+	Matcher Matcher
+}
+
+func (l *labeled) Matches(m captainslog.SyslogMsg) bool { return l.Matcher.Matches(m) }
+func (l *labeled) String() string                       { return fmt.Sprintf("%s: %s", l.Label, l.Matcher) }
+func (l *labeled) Encode(out map[string]interface{})    {}
+func (l *labeled) Decode(m map[string]interface{}) error {
+	return nil
+}
+func (l *labeled) Children() Matchers { return Matchers{l.Matcher} }
+func (l *labeled) WithChildren(cs Matchers) Matcher {
+	return &labeled{Label: l.Label, Matcher: cs[0]}
+}
+
+func TestWalk(t *testing.T) {
+	tree := NewNAryOp(And,
+		NewHostname(PrefixMatch, "web-"),
+		&labeled{"noisy", NewUnaryOp(Not, NewValue(Program, ExactMatch, "cron"))},
+		NewNAryOp(Or, NewSeverity(LessThan, captainslog.Warning), NewKV("status", GreaterThanEqual, 500)))
+
+	var b bytes.Buffer
+	Walk(tree, func(node Matcher, path Path) bool {
+		fmt.Fprintf(&b, "%s %T\n", path, node)
+		return true
+	})
+	want := "/ *matcher.NAryOp\n" +
+		"/0 *matcher.Hostname\n" +
+		"/1 *matcher.labeled\n" +
+		"/1/0 *matcher.UnaryOp\n" +
+		"/1/0/0 *matcher.Value\n" +
+		"/2 *matcher.NAryOp\n" +
+		"/2/0 *matcher.Severity\n" +
+		"/2/1 *matcher.KV\n"
+	if got := b.String(); want != got {
+		t.Errorf("want != got, want =\n%v\ngot =\n%v", want, got)
+	}
+
+	// Returning false skips the children of a node.
+	count := 0
+	Walk(tree, func(node Matcher, path Path) bool {
+		count++
+		_, ok := node.(*NAryOp)
+		return len(path) == 0 || !ok
+	})
+	if want, got := 6, count; want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	tree := NewNAryOp(And,
+		NewHostname(PrefixMatch, "web-"),
+		&labeled{"noisy", NewValue(Program, ExactMatch, "cron")},
+		NewUnaryOp(Not, NewHostname(PrefixMatch, "web-1")))
+	before := tree.String()
+
+	got := Transform(tree, func(m Matcher) Matcher {
+		if h, ok := m.(*Hostname); ok {
+			return NewHostname(h.MatchType, "app-"+h.NameMatcher)
+		}
+		return m
+	})
+
+	want := NewNAryOp(And,
+		NewHostname(PrefixMatch, "app-web-"),
+		&labeled{"noisy", NewValue(Program, ExactMatch, "cron")},
+		NewUnaryOp(Not, NewHostname(PrefixMatch, "app-web-1")))
+	if want.String() != got.String() {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+	if tree.String() != before {
+		t.Errorf("Transform modified its input %s", before)
+	}
+
+	// The function sees nodes whose children are already transformed.
+	got = Transform(tree, func(m Matcher) Matcher {
+		if o, ok := m.(*UnaryOp); ok {
+			if _, ok := o.Matcher.(*Constant); ok {
+				return NewConstant(false)
+			}
+		}
+		if _, ok := m.(*Hostname); ok {
+			return NewConstant(true)
+		}
+		return m
+	})
+	if want, got := "(true and noisy: program(exact_match, \"cron\") and false)", got.String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}
+
+func TestDiffCustomParent(t *testing.T) {
+	from := &labeled{"noisy", NewValue(Program, ExactMatch, "cron")}
+	to := &labeled{"noisy", NewValue(Program, ExactMatch, "anacron")}
+	want := "~ /0: program(exact_match, \"cron\") -> program(exact_match, \"anacron\")\n"
+	if got := Diff(from, to).String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+
+	to = &labeled{"quiet", NewValue(Program, ExactMatch, "cron")}
+	want = "~ /: noisy: program(exact_match, \"cron\") -> quiet: program(exact_match, \"cron\")\n"
+	if got := Diff(from, to).String(); want != got {
+		t.Errorf("want != got, want = %v, got = %v", want, got)
+	}
+}